@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/sedmess/go-ctx-base/actuator"
 	"github.com/sedmess/go-ctx-base/db"
@@ -11,7 +12,6 @@ import (
 	"github.com/sedmess/go-ctx/ctx"
 	"github.com/sedmess/go-ctx/logger"
 	"github.com/sedmess/go-ctx/u"
-	"gorm.io/gorm"
 	"net/http"
 	"strconv"
 	"strings"
@@ -107,10 +107,13 @@ type messageService struct {
 	messageTTL         time.Duration        `env:"MESSAGE_TTL" envDef:"24h"`
 	messageCleanupCron string               `env:"MESSAGE_CLEANUP_CRON" envDef:"0 0 * * * *"`
 	scheduler          *scheduler.Scheduler `inject:""`
+
+	messages *db.Repository[Message, int64]
 }
 
 func (s *messageService) Init() {
 	s.db.AutoMigrate(&Message{})
+	s.messages = db.NewRepository[Message, int64](s.db)
 
 	u.Must2(s.scheduler.ScheduleTaskCron(s.messageCleanupCron, "messages-cleanup", func() {
 		if err := s.removeMessagesBefore(time.Now().Add(-s.messageTTL)); err != nil {
@@ -120,39 +123,28 @@ func (s *messageService) Init() {
 }
 
 func (s *messageService) SaveMessage(from string, to string, text string) error {
-	return s.db.Session(func(session *db.Session) error {
-		return session.Tx(func(session *db.Session) error {
-			message := Message{
-				RecCreated: time.Now(),
-				Sender:     from,
-				Receiver:   to,
-				Text:       text,
-			}
-			s.l.Debug("storing message: from =", from, ", to =", to)
-			return session.Save(&message).Error
-		})
-	})
+	message := Message{
+		RecCreated: time.Now(),
+		Sender:     from,
+		Receiver:   to,
+		Text:       text,
+	}
+	s.l.Debug("storing message: from =", from, ", to =", to)
+	return s.messages.Save(nil, &message)
 }
 
 func (s *messageService) GetMessages(to string, since int64) channels.StreamingChan[Message] {
-	return db.SessionStream[Message](s.db, 2, func(session *gorm.DB) *gorm.DB {
-		return session.Where("receiver = ?", to).Where("id > ?", since).Order("id asc")
-	})
+	return s.messages.Stream(context.Background(), db.NewKeysetPaginator(2, db.Asc("id")), db.Where("receiver = ?", to), db.Where("id > ?", since))
 }
 
 func (s *messageService) removeMessagesBefore(time time.Time) error {
-	return s.db.Session(func(session *db.Session) error {
-		return session.Tx(func(session *db.Session) error {
-			result := session.Where("rec_created < ?", time).Delete(&Message{})
-			if result.Error != nil {
-				s.l.Error("on deleting messages before", time, ":", result.Error)
-				return result.Error
-			} else {
-				s.l.Info("deleted", result.RowsAffected, "rows before", time)
-				return nil
-			}
-		})
-	})
+	deleted, err := s.messages.DeleteAll(nil, db.Where("rec_created < ?", time))
+	if err != nil {
+		s.l.Error("on deleting messages before", time, ":", err)
+		return err
+	}
+	s.l.Info("deleted", deleted, "rows before", time)
+	return nil
 }
 
 type fsController struct {
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+const (
+	pgAdvisoryLockSql        = "select pg_advisory_lock(('x'||md5(?))::bit(64)::bigint)"
+	pgTryAdvisoryLockSql     = "select pg_try_advisory_lock(('x'||md5(?))::bit(64)::bigint)"
+	pgAdvisoryUnlockSql      = "select pg_advisory_unlock(('x'||md5(?))::bit(64)::bigint)"
+	mysqlAdvisoryLockSql     = "SELECT GET_LOCK(MD5(?), ?)"
+	mysqlAdvisoryUnlockSql   = "SELECT RELEASE_LOCK(MD5(?))"
+	sqlServerAdvisoryLockSql = `DECLARE @result int;
+EXEC @result = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = ?;
+SELECT @result`
+	sqlServerAdvisoryUnlockSql = "EXEC sp_releaseapplock @Resource = ?, @LockOwner = 'Session'"
+)
+
+var ErrAdvisoryLockUnsupported = errors.New("advisory locks are not supported by the DB dialect")
+var ErrAdvisoryLockNotAcquired = errors.New("advisory lock is held by another session")
+
+func AdvisoryLock(session *Session, key string) (func() error, error) {
+	return advisoryLock(session, key, true)
+}
+
+func TryAdvisoryLock(session *Session, key string) (func() error, error) {
+	return advisoryLock(session, key, false)
+}
+
+func advisoryLock(session *Session, key string, wait bool) (func() error, error) {
+	var acquired bool
+	var unlockSql string
+	switch session.Dialector.Name() {
+	case DriverPostgres:
+		unlockSql = pgAdvisoryUnlockSql
+		if wait {
+			if err := session.Exec(pgAdvisoryLockSql, key).Error; err != nil {
+				return nil, err
+			}
+			acquired = true
+		} else if err := session.Raw(pgTryAdvisoryLockSql, key).Scan(&acquired).Error; err != nil {
+			return nil, err
+		}
+	case DriverMySQL:
+		unlockSql = mysqlAdvisoryUnlockSql
+		timeout := 0
+		if wait {
+			timeout = -1
+		}
+		var result *int
+		if err := session.Raw(mysqlAdvisoryLockSql, key, timeout).Scan(&result).Error; err != nil {
+			return nil, err
+		}
+		acquired = result != nil && *result == 1
+	case DriverSQLServer:
+		unlockSql = sqlServerAdvisoryUnlockSql
+		timeout := 0
+		if wait {
+			timeout = -1
+		}
+		var result int
+		if err := session.Raw(sqlServerAdvisoryLockSql, key, timeout).Scan(&result).Error; err != nil {
+			return nil, err
+		}
+		acquired = result >= 0
+	default:
+		return nil, ErrAdvisoryLockUnsupported
+	}
+	if !acquired {
+		return nil, ErrAdvisoryLockNotAcquired
+	}
+	return func() error {
+		err := session.DB.WithContext(context.Background()).Exec(unlockSql, key).Error
+		if err != nil {
+			discardConn(session)
+		}
+		return err
+	}, nil
+}
+
+// discardConn drops the session connection from the pool, so session state that could not be reset (an advisory
+// lock, search_path) is cleared by the server on disconnect instead of passing to the next caller of the pool.
+func discardConn(session *Session) {
+	if conn, ok := session.Statement.ConnPool.(*sql.Conn); ok {
+		_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/sedmess/go-ctx/ctx"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const dbUrlKey = "DB_URL"
+
+const redactedPassword = "xxxxx"
+
+const defaultMySQLPort = "3306"
+
+var errUndefinedConnection = errors.New("undefined DB connection: set " + dbUrlKey + ", " + dbDsnKey + ", " +
+	dbHostKey + "/" + dbPortKey + "/" + dbUserKey + "/" + dbPasswordKey + "/" + dbNameKey + " or " + dbSqlitePathKey)
+
+var pgKeyValuePasswordRegexp = regexp.MustCompile(`(?i)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+var adoKeyValuePasswordRegexp = regexp.MustCompile(`(?i)((?:^|;)\s*(?:password|pwd)\s*=\s*)("(?:[^"]|"")*"|'(?:[^']|'')*'|\{(?:[^}]|\}\})*\}|[^;]*)`)
+
+type ConnectionInfo struct {
+	Name     string
+	Driver   string
+	DSN      string
+	Replicas []ConnectionInfo
+}
+
+type connectionConfig struct {
+	driver   string
+	dsn      string
+	replicas []string
+}
+
+func (instance *connection) resolveConfig() (*connectionConfig, error) {
+	driver := strings.ToLower(instance.getEnvFn(dbDriverKey).AsStringDefault(""))
+	if _, found := dialects[driver]; driver != "" && !found {
+		return nil, fmt.Errorf("unknown %s %q, expected one of %s, %s, %s, %s", dbDriverKey, driver, DriverPostgres, DriverSQLite, DriverMySQL, DriverSQLServer)
+	}
+
+	config, err := instance.resolvePrimary(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, replicaDsn := range instance.getEnvFn(dbReplicaDsnsKey).AsStringArrayDefault(nil) {
+		replicaDsn = strings.TrimSpace(replicaDsn)
+		if replicaDsn == "" {
+			return nil, fmt.Errorf("%s: replica %d has empty DSN", dbReplicaDsnsKey, i)
+		}
+		if strings.Contains(replicaDsn, "://") {
+			replicaDriver, dsn, err := parseDbUrl(replicaDsn)
+			if err != nil {
+				return nil, fmt.Errorf("%s: replica %d: %w", dbReplicaDsnsKey, i, err)
+			}
+			if replicaDriver != config.driver {
+				return nil, fmt.Errorf("%s: replica %d uses %s while primary uses %s", dbReplicaDsnsKey, i, replicaDriver, config.driver)
+			}
+			replicaDsn = dsn
+		}
+		config.replicas = append(config.replicas, replicaDsn)
+	}
+	return config, nil
+}
+
+func WithURL(dbUrl string) ConnectionOption {
+	return func(instance *connection) {
+		instance.url = dbUrl
+	}
+}
+
+func (instance *connection) resolvePrimary(driver string) (*connectionConfig, error) {
+	if instance.url != "" {
+		urlDriver, dsn, err := parseDbUrl(instance.url)
+		if err != nil {
+			return nil, err
+		}
+		return &connectionConfig{driver: urlDriver, dsn: dsn}, nil
+	}
+	if dbUrl := instance.getEnvFn(dbUrlKey); dbUrl.IsPresent() {
+		urlDriver, dsn, err := parseDbUrl(dbUrl.AsString())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dbUrlKey, err)
+		}
+		if driver != "" && driver != urlDriver {
+			return nil, fmt.Errorf("%s %q conflicts with %s scheme %q", dbDriverKey, driver, dbUrlKey, urlDriver)
+		}
+		return &connectionConfig{driver: urlDriver, dsn: dsn}, nil
+	}
+
+	if dbDsn := instance.getEnvFn(dbDsnKey); dbDsn.IsPresent() {
+		if driver == "" {
+			driver = DriverPostgres
+		}
+		return &connectionConfig{driver: driver, dsn: dbDsn.AsString()}, nil
+	}
+
+	hostKeys := []string{dbHostKey, dbPortKey, dbUserKey, dbPasswordKey, dbNameKey}
+	var missing []string
+	for _, key := range hostKeys {
+		if key == dbPasswordKey && instance.getEnvFn(dbPasswordFileKey).IsPresent() {
+			continue
+		}
+		if !instance.getEnvFn(key).IsPresent() {
+			missing = append(missing, key)
+		}
+	}
+	sqlitePath := instance.getEnvFn(dbSqlitePathKey)
+	if len(missing) < len(hostKeys) && (len(missing) == 0 || !sqlitePath.IsPresent()) {
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("incomplete DB settings: missing %s", strings.Join(missing, ", "))
+		}
+		if driver == "" {
+			driver = DriverPostgres
+		}
+		d := dialects[driver]
+		if d.buildDsn == nil {
+			return nil, fmt.Errorf("%s %s can't be configured by host settings, use %s or %s", dbDriverKey, driver, dbSqlitePathKey, dbUrlKey)
+		}
+		port, err := parsePort(instance.getEnvFn(dbPortKey).AsString())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dbPortKey, err)
+		}
+		password := instance.getEnvFn(dbPasswordKey).AsStringDefault("")
+		if passwordFile := instance.getEnvFn(dbPasswordFileKey); passwordFile.IsPresent() {
+			if password, err = readPasswordFile(passwordFile.AsString()); err != nil {
+				return nil, err
+			}
+		}
+		return &connectionConfig{driver: driver, dsn: d.buildDsn(dsnSettings{
+			host:     instance.getEnvFn(dbHostKey).AsString(),
+			port:     port,
+			user:     instance.getEnvFn(dbUserKey).AsString(),
+			password: password,
+			name:     instance.getEnvFn(dbNameKey).AsString(),
+			sslMode:  instance.getEnvFn(dbSSLModeKey).AsStringDefault(""),
+			timeZone: instance.getEnvFn(dbTimeZoneKey).AsStringDefault(ctx.GetEnv(globalTimeZoneKey).AsStringDefault("")),
+		})}, nil
+	}
+
+	// incomplete host settings fall back to DB_SQLITE_PATH
+	if sqlitePath.IsPresent() {
+		if driver != "" && driver != DriverSQLite {
+			return nil, fmt.Errorf("%s requires %s %s, got %q", dbSqlitePathKey, dbDriverKey, DriverSQLite, driver)
+		}
+		return &connectionConfig{driver: DriverSQLite, dsn: sqlitePath.AsString()}, nil
+	}
+
+	return nil, errUndefinedConnection
+}
+
+func parseDbUrl(rawUrl string) (string, string, error) {
+	scheme, rest, found := strings.Cut(rawUrl, "://")
+	if !found {
+		return "", "", errors.New("missing scheme, expected <driver>://...")
+	}
+
+	switch strings.ToLower(scheme) {
+	case "sqlite", "sqlite3":
+		if rest == "" || strings.HasPrefix(rest, "?") {
+			return "", "", errors.New("database path is required")
+		}
+		return DriverSQLite, rest, nil
+	case "postgres", "postgresql", "mysql", "sqlserver":
+	default:
+		return "", "", fmt.Errorf("unsupported scheme %q", scheme)
+	}
+
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", "", fmt.Errorf("malformed URL: %w", err)
+	}
+	if parsed.Hostname() == "" {
+		return "", "", errors.New("host is required")
+	}
+	if port := parsed.Port(); port != "" {
+		if _, err := parsePort(port); err != nil {
+			return "", "", err
+		}
+	}
+	name := strings.TrimPrefix(parsed.Path, "/")
+
+	switch strings.ToLower(scheme) {
+	case "sqlserver":
+		if parsed.Query().Get("database") == "" {
+			return "", "", errors.New("database query parameter is required")
+		}
+		return DriverSQLServer, rawUrl, nil
+	case "mysql":
+		if name == "" {
+			return "", "", errors.New("database name is required")
+		}
+		dsn, err := mysqlUrlDsn(parsed, name)
+		if err != nil {
+			return "", "", err
+		}
+		return DriverMySQL, dsn, nil
+	default:
+		if name == "" {
+			return "", "", errors.New("database name is required")
+		}
+		return DriverPostgres, rawUrl, nil
+	}
+}
+
+func mysqlUrlDsn(parsed *url.URL, name string) (string, error) {
+	port := parsed.Port()
+	if port == "" {
+		port = defaultMySQLPort
+	}
+	query := parsed.Query()
+	if !query.Has("parseTime") {
+		query.Set("parseTime", "true")
+	}
+	userInfo := ""
+	if parsed.User != nil {
+		userInfo = parsed.User.Username()
+		if password, set := parsed.User.Password(); set {
+			userInfo += ":" + password
+		}
+		userInfo += "@"
+	}
+	config, err := gomysql.ParseDSN(userInfo + "tcp(" + net.JoinHostPort(parsed.Hostname(), port) + ")/" + name + "?" + query.Encode())
+	if err != nil {
+		return "", fmt.Errorf("invalid MySQL parameters: %w", err)
+	}
+	return config.FormatDSN(), nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return port, nil
+}
+
+func redactDsn(driver string, dsn string) string {
+	if driver != DriverMySQL && strings.Contains(dsn, "://") {
+		parsed, err := url.Parse(dsn)
+		if err != nil {
+			return "<malformed DSN>"
+		}
+		if query := parsed.Query(); query.Has("password") {
+			query.Set("password", redactedPassword)
+			parsed.RawQuery = query.Encode()
+		}
+		return parsed.Redacted()
+	}
+	switch driver {
+	case DriverMySQL:
+		config, err := gomysql.ParseDSN(dsn)
+		if err != nil {
+			return "<malformed DSN>"
+		}
+		if config.Passwd != "" {
+			config.Passwd = redactedPassword
+		}
+		return config.FormatDSN()
+	case DriverPostgres:
+		return pgKeyValuePasswordRegexp.ReplaceAllString(dsn, "${1}"+redactedPassword)
+	}
+	return adoKeyValuePasswordRegexp.ReplaceAllString(dsn, "${1}"+redactedPassword)
+}
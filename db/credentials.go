@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	mssql "github.com/microsoft/go-mssqldb"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pgInvalidPassword      = "28P01"
+	pgInvalidAuthorization = "28000"
+	mysqlAccessDenied      = 1045
+	sqlServerLoginFailed   = 18456
+	defaultCredentialsTTL  = time.Minute
+)
+
+var ErrCredentialsUnsupported = errors.New("credential providers are not supported by the DB dialect")
+
+type Credentials struct {
+	User      string
+	Password  string
+	ExpiresAt time.Time
+}
+
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+type CredentialProviderFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialProviderFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+func WithCredentialProvider(provider CredentialProvider) ConnectionOption {
+	return func(instance *connection) {
+		instance.credentialProvider = provider
+	}
+}
+
+type passwordFileProvider struct {
+	path string
+}
+
+func (p *passwordFileProvider) Credentials(context.Context) (Credentials, error) {
+	password, err := readPasswordFile(p.path)
+	return Credentials{Password: password}, err
+}
+
+func readPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", dbPasswordFileKey, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+type credentialConnector struct {
+	dsn          string
+	provider     CredentialProvider
+	ttl          time.Duration
+	newConnector func(dsn string, credentials Credentials) (driver.Connector, error)
+
+	mu        sync.Mutex
+	connector driver.Connector
+	expiresAt time.Time
+}
+
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector, err := c.current(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connector.Connect(ctx)
+	if err != nil && isAuthError(err) {
+		if connector, err = c.current(ctx, true); err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return conn, err
+}
+
+func (c *credentialConnector) Driver() driver.Driver {
+	return credentialDriver{connector: c}
+}
+
+func (c *credentialConnector) current(ctx context.Context, refresh bool) (driver.Connector, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !refresh && c.connector != nil && time.Now().Before(c.expiresAt) {
+		return c.connector, nil
+	}
+	credentials, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't obtain DB credentials: %w", err)
+	}
+	connector, err := c.newConnector(c.dsn, credentials)
+	if err != nil {
+		return nil, err
+	}
+	c.connector = connector
+	if credentials.ExpiresAt.IsZero() {
+		c.expiresAt = time.Now().Add(c.ttl)
+	} else {
+		c.expiresAt = credentials.ExpiresAt
+	}
+	return connector, nil
+}
+
+type credentialDriver struct {
+	connector *credentialConnector
+}
+
+func (d credentialDriver) Open(string) (driver.Conn, error) {
+	return d.connector.Connect(context.Background())
+}
+
+func (instance *connection) openCredentialPool(dsn string) (*sql.DB, error) {
+	if instance.dialect.newConnector == nil {
+		return nil, ErrCredentialsUnsupported
+	}
+	if _, err := instance.dialect.newConnector(dsn, Credentials{}); err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(&credentialConnector{
+		dsn:          dsn,
+		provider:     instance.credentialProvider,
+		ttl:          instance.getEnvFn(dbCredentialsTTLKey).AsDurationDefault(defaultCredentialsTTL),
+		newConnector: instance.dialect.newConnector,
+	}), nil
+}
+
+func postgresConnector(dsn string, credentials Credentials) (driver.Connector, error) {
+	config, err := postgresConfig(dsn, credentials)
+	if err != nil {
+		return nil, err
+	}
+	return stdlib.GetConnector(*config), nil
+}
+
+func postgresConfig(dsn string, credentials Credentials) (*pgx.ConnConfig, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if credentials.User != "" {
+		config.User = credentials.User
+	}
+	config.Password = credentials.Password
+	return config, nil
+}
+
+func mysqlConnector(dsn string, credentials Credentials) (driver.Connector, error) {
+	config, err := gomysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if credentials.User != "" {
+		config.User = credentials.User
+	}
+	config.Passwd = credentials.Password
+	return gomysql.NewConnector(config)
+}
+
+func sqlServerConnector(dsn string, credentials Credentials) (driver.Connector, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil || parsed.Scheme != DriverSQLServer {
+		return nil, errors.New("credential providers require URL-style DSN for SQL Server")
+	}
+	user := credentials.User
+	if user == "" {
+		user = parsed.User.Username()
+	}
+	parsed.User = url.UserPassword(user, credentials.Password)
+	return mssql.NewConnector(parsed.String())
+}
+
+func isAuthError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgInvalidPassword || pgErr.Code == pgInvalidAuthorization
+	}
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlAccessDenied
+	}
+	var sqlServerErr mssql.Error
+	if errors.As(err, &sqlServerErr) {
+		return sqlServerErr.Number == sqlServerLoginFailed
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	gm "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeConn struct {
+	driver.Conn
+}
+
+type fakeConnector struct {
+	password string
+	accepted *string
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	if c.password != *c.accepted {
+		return nil, &pgconn.PgError{Code: pgInvalidPassword}
+	}
+	return fakeConn{}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+func Test_CredentialConnector(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	gm.Expect(os.WriteFile(passwordFile, []byte("first\n"), 0o600)).Should(gm.BeNil())
+
+	accepted := "first"
+	created := 0
+	connector := &credentialConnector{
+		dsn:      "test",
+		provider: &passwordFileProvider{path: passwordFile},
+		ttl:      time.Hour,
+		newConnector: func(dsn string, credentials Credentials) (driver.Connector, error) {
+			created++
+			return &fakeConnector{password: credentials.Password, accepted: &accepted}, nil
+		},
+	}
+
+	_, err := connector.Connect(context.Background())
+	gm.Expect(err).Should(gm.BeNil())
+	_, err = connector.Connect(context.Background())
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(created).Should(gm.Equal(1))
+
+	accepted = "second"
+	gm.Expect(os.WriteFile(passwordFile, []byte("second\n"), 0o600)).Should(gm.BeNil())
+	_, err = connector.Connect(context.Background())
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(created).Should(gm.Equal(2))
+
+	accepted = "third"
+	_, err = connector.Connect(context.Background())
+	gm.Expect(isAuthError(err)).Should(gm.BeTrue())
+
+	expiring := 0
+	connector.provider = CredentialProviderFunc(func(ctx context.Context) (Credentials, error) {
+		expiring++
+		return Credentials{Password: "third", ExpiresAt: time.Now().Add(-time.Second)}, nil
+	})
+	connector.connector = nil
+	for i := 0; i < 2; i++ {
+		_, err = connector.Connect(context.Background())
+		gm.Expect(err).Should(gm.BeNil())
+	}
+	gm.Expect(expiring).Should(gm.Equal(2))
+
+	connector.provider = CredentialProviderFunc(func(ctx context.Context) (Credentials, error) {
+		return Credentials{}, errors.New("vault is sealed")
+	})
+	_, err = connector.Connect(context.Background())
+	gm.Expect(err).Should(gm.MatchError(gm.ContainSubstring("vault is sealed")))
+}
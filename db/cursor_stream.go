@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx-base/utils/channels"
+	"github.com/sedmess/go-ctx/logger"
+	"gorm.io/gorm"
+)
+
+const streamCursorName = "ctx_base_stream_cursor"
+
+func SessionCursorStream[T any](connection Connection, fetchSize int, selectFn func(session *gorm.DB) *gorm.DB) channels.StreamingChan[T] {
+	return SessionContextCursorStream[T](context.Background(), connection, fetchSize, selectFn)
+}
+
+func SessionContextCursorStream[T any](ctx context.Context, connection Connection, fetchSize int, selectFn func(session *gorm.DB) *gorm.DB) channels.StreamingChan[T] {
+	return channels.CreateChannelBuffered[T](fetchSize, func(sink func(data []T, context context.Context) bool) error {
+		return connection.SessionContext(streamContext(ctx), func(session *Session) error {
+			if session.Dialector.Name() == DriverPostgres {
+				return session.Tx(func(session *Session) error {
+					return streamDeclaredCursor(session, fetchSize, selectFn, sink)
+				})
+			}
+			return streamRows(session, fetchSize, selectFn, sink)
+		})
+	})
+}
+
+func streamDeclaredCursor[T any](session *Session, fetchSize int, selectFn func(session *gorm.DB) *gorm.DB, sink func(data []T, context context.Context) bool) error {
+	var dest []T
+	stmt := selectFn(session.Session(&gorm.Session{DryRun: true}).Model(new(T))).Find(&dest).Statement
+	if stmt.Error != nil {
+		return stmt.Error
+	}
+	if _, err := session.Statement.ConnPool.ExecContext(session, "DECLARE "+streamCursorName+" NO SCROLL CURSOR FOR "+stmt.SQL.String(), stmt.Vars...); err != nil {
+		return err
+	}
+	defer func() {
+		if err := session.Exec("CLOSE " + streamCursorName).Error; err != nil {
+			logger.Error("DB", "on closing stream cursor:", err)
+		}
+	}()
+
+	fetchSql := fmt.Sprintf("FETCH %d FROM %s", fetchSize, streamCursorName)
+	for {
+		rows, err := session.Raw(fetchSql).Rows()
+		if err != nil {
+			return err
+		}
+		count, err := scanAndSink(session, rows, fetchSize, sink)
+		if err != nil {
+			return err
+		}
+		if count < fetchSize {
+			return nil
+		}
+	}
+}
+
+func streamRows[T any](session *Session, fetchSize int, selectFn func(session *gorm.DB) *gorm.DB, sink func(data []T, context context.Context) bool) error {
+	rows, err := selectFn(session.Model(new(T))).Rows()
+	if err != nil {
+		return err
+	}
+	_, err = scanAndSink(session, rows, fetchSize, sink)
+	return err
+}
+
+func scanAndSink[T any](session *Session, rows *sql.Rows, batchSize int, sink func(data []T, context context.Context) bool) (int, error) {
+	defer func() { _ = rows.Close() }()
+
+	count := 0
+	batch := make([]T, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if !sink(batch, session) {
+			logger.Error("DB", "breaking TX by timeout")
+			return errors.New("transaction timeout expired")
+		}
+		batch = make([]T, 0, batchSize)
+		return nil
+	}
+	for rows.Next() {
+		var item T
+		if err := session.ScanRows(rows, &item); err != nil {
+			return count, err
+		}
+		batch = append(batch, item)
+		count++
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, flush()
+}
@@ -5,24 +5,27 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/glebarez/sqlite"
 	"github.com/sedmess/go-ctx/ctx"
 	"github.com/sedmess/go-ctx/ctx/health"
 	"github.com/sedmess/go-ctx/logger"
 	"github.com/sedmess/go-ctx/u"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	glogger "gorm.io/gorm/logger"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const dbSqlitePathKey = "DB_SQLITE_PATH"
+const dbDriverKey = "DB_DRIVER"
 const dbDsnKey = "DB_DSN"
 const dbHostKey = "DB_HOST"
 const dbPortKey = "DB_PORT"
 const dbUserKey = "DB_USERNAME"
 const dbPasswordKey = "DB_PASSWORD"
+const dbPasswordFileKey = "DB_PASSWORD_FILE"
+const dbCredentialsTTLKey = "DB_CREDENTIALS_TTL"
 const dbNameKey = "DB_NAME"
 const dbTimeZoneKey = "DB_TIMEZONE"
 const globalTimeZoneKey = "TZ"
@@ -30,13 +33,39 @@ const dbSSLModeKey = "DB_SSLMODE"
 const dbMaxIdleConnsKey = "DB_MAX_IDLE_CONNS"
 const dbMaxOpenConnsKey = "DB_MAX_OPEN_CONNS"
 const dbConnMaxLifetimeKey = "DB_CONN_MAX_LIFETIME"
+const dbReplicaDsnsKey = "DB_REPLICA_DSNS"
+const dbReplicaBalancerKey = "DB_REPLICA_BALANCER"
+const dbReplicaEjectTimeoutKey = "DB_REPLICA_EJECT_TIMEOUT"
+const dbConnectRetriesKey = "DB_CONNECT_RETRIES"
+const dbQueryTimeoutKey = "DB_QUERY_TIMEOUT"
+const dbTxTimeoutKey = "DB_TX_TIMEOUT"
+const dbLogLevelKey = "DB_LOG_LEVEL"
+const dbLogParametersKey = "DB_LOG_PARAMETERS"
+const dbSlowQueryThresholdKey = "DB_SLOW_QUERY_THRESHOLD"
+const dbSlowQueryTopNKey = "DB_SLOW_QUERY_TOP_N"
+const dbConnectBackoffKey = "DB_CONNECT_BACKOFF"
+
+const defaultConnectBackoff = time.Second
+const maxConnectBackoff = 30 * time.Second
+
+var ErrConnectionUnavailable = errors.New("DB connection is unavailable")
 
 const dbDsnPattern = "host=%s port=%d user=%s password=%s dbname=%s sslmode=%s"
 const dbDsnTimeZonePatternAddition = " TimeZone=%s"
 
-const dbCheckQuery = "select null"
+type ConnectionOption func(instance *connection)
+
+type MigrationRunner interface {
+	Migrate(session *Session) error
+}
+
+func WithMigrationRunner(runner MigrationRunner) ConnectionOption {
+	return func(instance *connection) {
+		instance.migrationRunner = runner
+	}
+}
 
-func NewConnection(name string, configPrefix string, isDefault bool, isCritical bool) Connection {
+func NewConnection(name string, configPrefix string, isDefault bool, isCritical bool, options ...ConnectionOption) Connection {
 	var getEnvFn func(string) *ctx.EnvValue
 	if isDefault {
 		getEnvFn = func(key string) *ctx.EnvValue {
@@ -47,7 +76,11 @@ func NewConnection(name string, configPrefix string, isDefault bool, isCritical
 			return ctx.GetEnvCustom(strings.ToUpper(configPrefix), key)
 		}
 	}
-	return &connection{name: name, getEnvFn: getEnvFn, isCritical: isCritical}
+	instance := &connection{name: name, getEnvFn: getEnvFn, isCritical: isCritical, metrics: newMetricsPlugin(), slowQueries: newSlowQueryTracker(defaultSlowQueryTopN)}
+	for _, option := range options {
+		option(instance)
+	}
+	return instance
 }
 
 type Connection interface {
@@ -55,6 +88,11 @@ type Connection interface {
 	AutoMigrate(models ...any)
 	Session(session func(session *Session) error) error
 	SessionContext(context context.Context, session func(session *Session) error) error
+	ReadSession(session func(session *Session) error) error
+	ReadSessionContext(context context.Context, session func(session *Session) error) error
+	Info() ConnectionInfo
+	Metrics() Metrics
+	SlowQueries() []SlowQuery
 	Stats() (sql.DBStats, error)
 	Check() error
 	Health() health.ServiceHealth
@@ -64,63 +102,265 @@ type connection struct {
 	name       string
 	getEnvFn   func(name string) *ctx.EnvValue
 	isCritical bool
+	url        string
 
-	logger logger.Logger
+	migrationRunner    MigrationRunner
+	credentialProvider CredentialProvider
 
-	db *gorm.DB
+	logger      logger.Logger
+	dialect     *dialect
+	dsn         string
+	info        ConnectionInfo
+	metrics     *metricsPlugin
+	slowQueries *slowQueryTracker
+	gormLogger  glogger.Interface
+	timeouts    *timeoutsPlugin
+	tenancy     *tenancy
+
+	sqliteSingleWriter bool
+	sqliteReader       *gorm.DB
+
+	db            atomic.Pointer[gorm.DB]
+	replicas      *replicaPool
+	connectPolicy RetryPolicy
+	mu            sync.Mutex
+	connectErr    error
+	pendingModels []any
+	stopFn        context.CancelFunc
 }
 
 func (instance *connection) Init() {
 	instance.logger = logger.New(instance)
 
-	var dbProvider gorm.Dialector
-
-	sqlitePath := instance.getEnvFn(dbSqlitePathKey)
-	dbDsn := instance.getEnvFn(dbDsnKey)
-	dbHost := instance.getEnvFn(dbHostKey)
-	dbPort := instance.getEnvFn(dbPortKey)
-	dbUser := instance.getEnvFn(dbUserKey)
-	dbName := instance.getEnvFn(dbNameKey)
-	dbPassword := instance.getEnvFn(dbPasswordKey)
-	dbSSLMode := instance.getEnvFn(dbSSLModeKey)
-	dbTimeZone := instance.getEnvFn(dbTimeZoneKey)
-	globalTimeZone := ctx.GetEnv(globalTimeZoneKey)
-	if dbDsn.IsPresent() {
-		instance.logger.Info("use Postgres DB")
-		dbProvider = postgres.Open(dbDsn.AsString())
-	} else if instance.presentAll(dbHost, dbPort, dbUser, dbPassword, dbName) {
-		dsn := fmt.Sprintf(dbDsnPattern, dbHost.AsString(), dbPort.AsInt(), dbUser.AsString(), dbPassword.AsString(), dbName.AsString(), dbSSLMode.AsStringDefault("disable"))
-		if dbTimeZone.IsPresent() {
-			dsn += fmt.Sprintf(dbDsnTimeZonePatternAddition, dbTimeZone.AsString())
-		} else if globalTimeZone.IsPresent() {
-			dsn += fmt.Sprintf(dbDsnTimeZonePatternAddition, globalTimeZone.AsString())
-		}
-		instance.logger.Info("use Postgres DB")
-		dbProvider = postgres.Open(dsn)
-	} else if sqlitePath.IsPresent() {
-		instance.logger.Info("use SQLite DB")
-		dbProvider = sqlite.Open(sqlitePath.AsString())
-	} else {
-		instance.logger.Fatal("undefined DB connection")
-	}
-
-	instance.db = u.Must2(
-		gorm.Open(
-			dbProvider,
-			&gorm.Config{
-				PrepareStmt:    true,
-				TranslateError: true,
-				Logger: glogger.New(logger.GetLogger(logger.DEBUG), glogger.Config{
-					SlowThreshold:             0,
-					Colorful:                  false,
-					IgnoreRecordNotFoundError: true,
-					ParameterizedQueries:      true,
-					LogLevel:                  glogger.Error,
-				}),
-			},
-		),
+	config, err := instance.resolveConfig()
+	if err != nil {
+		instance.logger.Fatal(err)
+	}
+	if config.driver == DriverSQLite {
+		if err := instance.configureSQLite(config); err != nil {
+			instance.logger.Fatal(err)
+		}
+	}
+	instance.dialect = dialects[config.driver]
+	instance.dsn = config.dsn
+	if passwordFile := instance.getEnvFn(dbPasswordFileKey); passwordFile.IsPresent() && instance.credentialProvider == nil && instance.dialect.newConnector != nil {
+		instance.credentialProvider = &passwordFileProvider{path: passwordFile.AsString()}
+	}
+	instance.info = ConnectionInfo{Name: instance.name, Driver: config.driver, DSN: redactDsn(config.driver, config.dsn)}
+	instance.logger.Info("use", instance.dialect.title, "DB:", instance.info.DSN)
+
+	if err := instance.configureLogging(); err != nil {
+		instance.logger.Fatal(err)
+	}
+	instance.timeouts = &timeoutsPlugin{
+		queryTimeout: instance.getEnvFn(dbQueryTimeoutKey).AsDurationDefault(0),
+		txTimeout:    instance.getEnvFn(dbTxTimeoutKey).AsDurationDefault(0),
+	}
+	instance.connectPolicy = RetryPolicy{
+		MaxAttempts:    instance.getEnvFn(dbConnectRetriesKey).AsIntDefault(0) + 1,
+		InitialBackoff: instance.getEnvFn(dbConnectBackoffKey).AsDurationDefault(defaultConnectBackoff),
+		MaxBackoff:     maxConnectBackoff,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+
+	replicas := make([]*replica, 0, len(config.replicas))
+	for i, dsn := range config.replicas {
+		r := &replica{name: fmt.Sprintf("replica-%d", i), dsn: redactDsn(config.driver, dsn)}
+		if r.db, err = instance.open(dsn, false); err != nil {
+			if instance.isCritical {
+				instance.logger.Fatal("can't open read replica", r.name, ":", err)
+			}
+			instance.logger.Error("read replica", r.name, "skipped:", err)
+			continue
+		}
+		instance.info.Replicas = append(instance.info.Replicas, ConnectionInfo{Name: r.name, Driver: config.driver, DSN: r.dsn})
+		instance.logger.Info("use read replica", r.name, ":", r.dsn)
+		replicas = append(replicas, r)
+	}
+	instance.replicas = newReplicaPool(
+		instance.logger,
+		replicas,
+		strings.ToUpper(instance.getEnvFn(dbReplicaBalancerKey).AsStringDefault(balancerRoundRobin)),
+		instance.getEnvFn(dbReplicaEjectTimeoutKey).AsDurationDefault(defaultReplicaEjectTimeout),
+	)
+
+	if instance.sqliteSingleWriter {
+		if instance.sqliteReader, err = instance.openSqliteReader(config.dsn); err != nil {
+			instance.logger.Fatal("can't open SQLite reader pool:", err)
+		}
+	}
+
+	db, err := instance.connect(config.dsn)
+	if err != nil {
+		if instance.isCritical {
+			instance.logger.Fatal("can't connect to DB:", err)
+		}
+		instance.logger.Error("DB is unavailable, starting in degraded mode:", err)
+		instance.connectErr = err
+		var reconnectContext context.Context
+		reconnectContext, instance.stopFn = context.WithCancel(context.Background())
+		go instance.reconnect(reconnectContext, config.dsn)
+		return
+	}
+	if err := instance.ready(db); err != nil {
+		instance.logger.Fatal("migration failed:", err)
+	}
+}
+
+func (instance *connection) AfterStart() {
+}
+
+func (instance *connection) BeforeStop() {
+	if instance.stopFn != nil {
+		instance.stopFn()
+	}
+}
+
+func (instance *connection) connect(dsn string) (*gorm.DB, error) {
+	for attempt := 1; ; attempt++ {
+		db, err := instance.openPrimary(dsn)
+		if err == nil || attempt >= instance.connectPolicy.MaxAttempts {
+			return db, err
+		}
+		backoff := instance.connectPolicy.Backoff(attempt)
+		instance.logger.Error("DB is unavailable, retrying in", backoff, ":", err)
+		time.Sleep(backoff)
+	}
+}
+
+func (instance *connection) reconnect(ctx context.Context, dsn string) {
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(instance.connectPolicy.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		db, err := instance.openPrimary(dsn)
+		if err != nil {
+			instance.logger.Debug("reconnect attempt", attempt, "failed:", err)
+			instance.mu.Lock()
+			instance.connectErr = err
+			instance.mu.Unlock()
+			continue
+		}
+		instance.logger.Info("DB connection established after", attempt, "reconnect attempts")
+		if err := instance.ready(db); err != nil {
+			instance.logger.Error("migration failed, will retry:", err)
+			instance.mu.Lock()
+			instance.connectErr = err
+			instance.mu.Unlock()
+			if sqlDb, dbErr := db.DB(); dbErr == nil {
+				_ = sqlDb.Close()
+			}
+			continue
+		}
+		return
+	}
+}
+
+func (instance *connection) ready(db *gorm.DB) error {
+	if instance.migrationRunner != nil {
+		if err := instance.session(db, context.Background(), instance.migrationRunner.Migrate); err != nil {
+			return err
+		}
+	}
+
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+
+	if len(instance.pendingModels) > 0 {
+		instance.logger.LogIfError("on deferred auto migration", instance.autoMigrate(db, instance.pendingModels...))
+		instance.pendingModels = nil
+	}
+	instance.connectErr = nil
+	instance.db.Store(db)
+	return nil
+}
+
+func (instance *connection) primary() (*gorm.DB, error) {
+	if db := instance.db.Load(); db != nil {
+		return db, nil
+	}
+	return nil, ErrConnectionUnavailable
+}
+
+func (instance *connection) configureLogging() error {
+	logLevel := glogger.Error
+	logWriter := logger.GetLogger(logger.DEBUG)
+	if level := instance.getEnvFn(dbLogLevelKey); level.IsPresent() {
+		var err error
+		if logLevel, err = parseLogLevel(level.AsString()); err != nil {
+			return err
+		}
+		logWriter = logger.GetLogger(logger.INFO)
+	}
+	slowThreshold := instance.getEnvFn(dbSlowQueryThresholdKey).AsDurationDefault(defaultSlowQueryThreshold)
+	instance.slowQueries.topN = instance.getEnvFn(dbSlowQueryTopNKey).AsIntDefault(defaultSlowQueryTopN)
+	instance.gormLogger = &queryLogger{
+		Interface: glogger.New(logWriter, glogger.Config{
+			SlowThreshold:             slowThreshold,
+			Colorful:                  false,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      !instance.getEnvFn(dbLogParametersKey).AsBoolDefault(false),
+			LogLevel:                  logLevel,
+		}),
+		slowThreshold: slowThreshold,
+		slowQueries:   instance.slowQueries,
+	}
+	return nil
+}
+
+func (instance *connection) dialector(dsn string) (gorm.Dialector, error) {
+	if instance.credentialProvider == nil {
+		return instance.dialect.open(dsn), nil
+	}
+	pool, err := instance.openCredentialPool(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return instance.dialect.openConn(pool), nil
+}
+
+func (instance *connection) open(dsn string, ping bool) (*gorm.DB, error) {
+	dbProvider, err := instance.dialector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(
+		dbProvider,
+		&gorm.Config{
+			PrepareStmt:          true,
+			TranslateError:       true,
+			DisableAutomaticPing: !ping,
+			Logger:               instance.gormLogger,
+		},
 	)
-	sqlDb := u.Must2(instance.db.DB())
+	if err != nil {
+		if db != nil {
+			if sqlDb, dbErr := db.DB(); dbErr == nil {
+				_ = sqlDb.Close()
+			}
+		}
+		return nil, err
+	}
+	if err := db.Use(instance.metrics); err != nil {
+		return nil, err
+	}
+	if err := db.Use(instance.timeouts); err != nil {
+		return nil, err
+	}
+	if instance.tenancy != nil {
+		if err := db.Use(instance.tenancy); err != nil {
+			return nil, err
+		}
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
 	dbMaxIdleConns := instance.getEnvFn(dbMaxIdleConnsKey)
 	if dbMaxIdleConns.IsPresent() {
 		sqlDb.SetMaxIdleConns(dbMaxIdleConns.AsInt())
@@ -133,34 +373,108 @@ func (instance *connection) Init() {
 	if dbConnMaxLifetime.IsPresent() {
 		sqlDb.SetConnMaxLifetime(dbConnMaxLifetime.AsDuration())
 	}
+	return db, nil
 }
 
 func (instance *connection) AutoMigrate(models ...any) {
-	u.Must(instance.Session(func(session *Session) error {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+
+	db, err := instance.primary()
+	if err != nil {
+		instance.logger.Info("DB is unavailable, auto migration deferred until connected")
+		instance.pendingModels = append(instance.pendingModels, models...)
+		return
+	}
+	u.Must(instance.autoMigrate(db, models...))
+}
+
+func (instance *connection) autoMigrate(db *gorm.DB, models ...any) error {
+	return instance.session(db, context.Background(), func(session *Session) error {
 		return session.Tx(func(session *Session) error {
 			return session.AutoMigrate(models...)
 		})
-	}))
+	})
 }
 
 func (instance *connection) Session(dbFunc func(session *Session) error) error {
-	return instance.db.Connection(func(db *gorm.DB) error {
-		return dbFunc(newSession(context.Background(), db))
-	})
+	return instance.SessionContext(context.Background(), dbFunc)
 }
 
 func (instance *connection) SessionContext(baseContext context.Context, dbFunc func(session *Session) error) error {
-	return instance.db.Connection(func(db *gorm.DB) error {
-		return dbFunc(newSession(baseContext, db))
-	})
+	db, err := instance.primary()
+	if err != nil {
+		return err
+	}
+	if instance.sqliteReader != nil {
+		return instance.openSession(baseContext, instance.sqliteReader, db, true, dbFunc)
+	}
+	return instance.openSession(baseContext, db, nil, true, dbFunc)
+}
+
+func (instance *connection) session(db *gorm.DB, baseContext context.Context, dbFunc func(session *Session) error) error {
+	return instance.openSession(baseContext, db, nil, false, dbFunc)
+}
+
+func (instance *connection) ReadSession(dbFunc func(session *Session) error) error {
+	return instance.ReadSessionContext(context.Background(), dbFunc)
+}
+
+func (instance *connection) ReadSessionContext(baseContext context.Context, dbFunc func(session *Session) error) error {
+	r := instance.replicas.pick()
+	if r == nil {
+		return instance.SessionContext(baseContext, dbFunc)
+	}
+	primary, err := instance.primary()
+	if err != nil {
+		return err
+	}
+	err = instance.openSession(baseContext, r.db, primary, true, dbFunc)
+	if err != nil && isConnectionError(err) {
+		instance.replicas.eject(r, err)
+	}
+	return err
 }
 
 func (instance *connection) Name() string {
 	return instance.name
 }
 
+func (instance *connection) Info() ConnectionInfo {
+	return instance.info
+}
+
+func (instance *connection) Close() error {
+	if instance.stopFn != nil {
+		instance.stopFn()
+	}
+	dbs := make([]*gorm.DB, 0, 1+len(instance.replicas.replicas))
+	if db, err := instance.primary(); err == nil {
+		dbs = append(dbs, db)
+	}
+	if instance.sqliteReader != nil {
+		dbs = append(dbs, instance.sqliteReader)
+	}
+	for _, r := range instance.replicas.replicas {
+		dbs = append(dbs, r.db)
+	}
+	var errs []error
+	for _, db := range dbs {
+		sqlDb, err := db.DB()
+		if err == nil {
+			err = sqlDb.Close()
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 func (instance *connection) Stats() (sql.DBStats, error) {
-	sqlDb, err := instance.db.DB()
+	db, err := instance.primary()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	sqlDb, err := db.DB()
 	if err != nil {
 		instance.logger.Error("error on gathering DBStats:", err)
 		return sql.DBStats{}, errors.New("can't get sql.DB instance")
@@ -172,13 +486,22 @@ func (instance *connection) Stats() (sql.DBStats, error) {
 func (instance *connection) Check() error {
 	timeoutContext, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelFn()
-	_, err := instance.db.ConnPool.QueryContext(timeoutContext, dbCheckQuery)
-	return err
+	db, err := instance.primary()
+	if err != nil {
+		instance.mu.Lock()
+		defer instance.mu.Unlock()
+		if instance.connectErr != nil {
+			return fmt.Errorf("%w: %v", err, instance.connectErr)
+		}
+		return err
+	}
+	return checkConnection(timeoutContext, db)
 }
 
 func (instance *connection) Health() health.ServiceHealth {
 	status := health.ServiceHealth{}
 	status.Details = make(map[string]any)
+	status.Details["dsn"] = instance.info.DSN
 	err := instance.Check()
 	if err == nil {
 		stats, err := instance.Stats()
@@ -186,6 +509,12 @@ func (instance *connection) Health() health.ServiceHealth {
 			status.Details["stats"] = stats
 		}
 		status.Status = health.Up
+		if replicasHealth, allUp := instance.replicas.health(); len(replicasHealth) > 0 {
+			status.Components = replicasHealth
+			if !allUp {
+				status.Status = health.Partially
+			}
+		}
 		return status
 	}
 	status.Details["error"] = err.Error()
@@ -196,12 +525,3 @@ func (instance *connection) Health() health.ServiceHealth {
 	}
 	return status
 }
-
-func (instance *connection) presentAll(values ...*ctx.EnvValue) bool {
-	for _, value := range values {
-		if !value.IsPresent() {
-			return false
-		}
-	}
-	return true
-}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"errors"
+	gm "github.com/onsi/gomega"
+	"github.com/sedmess/go-ctx/ctx/health"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type degradedItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+func Test_DegradedStart(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	dir := filepath.Join(t.TempDir(), "missing")
+	_ = os.Setenv("DEGRADED_TEST_DB_SQLITE_PATH", filepath.Join(dir, "app.db"))
+	_ = os.Setenv("DEGRADED_TEST_DB_CONNECT_RETRIES", "1")
+	_ = os.Setenv("DEGRADED_TEST_DB_CONNECT_BACKOFF", "10ms")
+
+	conn := NewConnection("degraded_test", "degraded_test", false, false)
+	conn.Init()
+	defer conn.(*connection).BeforeStop()
+	conn.AutoMigrate(&degradedItem{})
+
+	gm.Expect(conn.Health().Status).Should(gm.Equal(health.Down))
+	err := conn.Session(func(session *Session) error { return nil })
+	gm.Expect(errors.Is(err, ErrConnectionUnavailable)).Should(gm.BeTrue())
+	gm.Expect(errors.Is(conn.Check(), ErrConnectionUnavailable)).Should(gm.BeTrue())
+
+	gm.Expect(os.MkdirAll(dir, 0o755)).Should(gm.BeNil())
+	gm.Eventually(conn.Check, 5*time.Second, 10*time.Millisecond).Should(gm.BeNil())
+	gm.Expect(conn.Health().Status).Should(gm.Equal(health.Up))
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		return session.Create(&degradedItem{Id: 1, Name: "after reconnect"}).Error
+	})).Should(gm.BeNil())
+}
+
+type failingMigrationRunner struct{}
+
+func (failingMigrationRunner) Migrate(*Session) error {
+	return errors.New("broken migration")
+}
+
+func Test_DegradedStartMigrationFailure(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	dir := filepath.Join(t.TempDir(), "missing")
+	_ = os.Setenv("DEGRADED_MIGRATION_TEST_DB_SQLITE_PATH", filepath.Join(dir, "app.db"))
+	_ = os.Setenv("DEGRADED_MIGRATION_TEST_DB_CONNECT_BACKOFF", "10ms")
+	_ = os.Setenv("DEGRADED_MIGRATION_TEST_DB_REPLICA_DSNS", "file:degraded_migration_r0:?mode=memory&cache=shared")
+
+	conn := NewConnection("degraded_migration_test", "degraded_migration_test", false, false, WithMigrationRunner(failingMigrationRunner{}))
+	conn.Init()
+	defer conn.(*connection).BeforeStop()
+
+	gm.Expect(os.MkdirAll(dir, 0o755)).Should(gm.BeNil())
+	gm.Eventually(func() string {
+		err := conn.Check()
+		if err == nil {
+			return ""
+		}
+		return err.Error()
+	}, 5*time.Second, 10*time.Millisecond).Should(gm.ContainSubstring("broken migration"))
+	gm.Expect(conn.Health().Status).Should(gm.Equal(health.Down))
+
+	err := conn.ReadSession(func(session *Session) error { return nil })
+	gm.Expect(errors.Is(err, ErrConnectionUnavailable)).Should(gm.BeTrue())
+}
+
+type flakyMigrationRunner struct {
+	failures atomic.Int64
+}
+
+func (r *flakyMigrationRunner) Migrate(*Session) error {
+	if r.failures.Add(-1) >= 0 {
+		return errors.New("flaky migration")
+	}
+	return nil
+}
+
+func Test_DegradedStartMigrationRetry(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	dir := filepath.Join(t.TempDir(), "missing")
+	_ = os.Setenv("DEGRADED_RETRY_TEST_DB_SQLITE_PATH", filepath.Join(dir, "app.db"))
+	_ = os.Setenv("DEGRADED_RETRY_TEST_DB_CONNECT_BACKOFF", "10ms")
+
+	runner := &flakyMigrationRunner{}
+	runner.failures.Store(2)
+	conn := NewConnection("degraded_retry_test", "degraded_retry_test", false, false, WithMigrationRunner(runner))
+	conn.Init()
+	defer conn.(*connection).BeforeStop()
+
+	gm.Expect(os.MkdirAll(dir, 0o755)).Should(gm.BeNil())
+	gm.Eventually(conn.Check, 5*time.Second, 10*time.Millisecond).Should(gm.BeNil())
+	gm.Expect(conn.Health().Status).Should(gm.Equal(health.Up))
+	gm.Expect(runner.failures.Load()).Should(gm.BeNumerically("<", 0))
+}
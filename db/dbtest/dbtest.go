@@ -0,0 +1,172 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx-base/db"
+	"github.com/sedmess/go-ctx/ctx"
+	"github.com/sedmess/go-ctx/ctx/ctx_testing"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var errRollback = errors.New("dbtest: rollback")
+
+var counter atomic.Int64
+
+var unsafeNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+type Option func(options *options)
+
+type options struct {
+	models            []any
+	fixtures          []string
+	connectionOptions []db.ConnectionOption
+}
+
+func WithModels(models ...any) Option {
+	return func(options *options) {
+		options.models = append(options.models, models...)
+	}
+}
+
+func WithMigrations(runner db.MigrationRunner) Option {
+	return WithConnectionOptions(db.WithMigrationRunner(runner))
+}
+
+func WithFixtures(paths ...string) Option {
+	return func(options *options) {
+		options.fixtures = append(options.fixtures, paths...)
+	}
+}
+
+func WithConnectionOptions(connectionOptions ...db.ConnectionOption) Option {
+	return func(options *options) {
+		options.connectionOptions = append(options.connectionOptions, connectionOptions...)
+	}
+}
+
+func NewConnection(t testing.TB, opts ...Option) db.Connection {
+	t.Helper()
+
+	var options options
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	name := fmt.Sprintf("dbtest_%d_%s", counter.Add(1), strings.Trim(unsafeNameRegexp.ReplaceAllString(t.Name(), "_"), "_"))
+	connectionOptions := append([]db.ConnectionOption{db.WithURL("sqlite://file:" + name + "?mode=memory&cache=shared")}, options.connectionOptions...)
+	conn := db.NewConnection(name, name, false, true, connectionOptions...)
+	conn.Init()
+
+	t.Cleanup(func() {
+		if err := conn.(io.Closer).Close(); err != nil {
+			t.Error("dbtest: on closing connection:", err)
+		}
+	})
+
+	if len(options.models) > 0 {
+		conn.AutoMigrate(options.models...)
+	}
+	if len(options.fixtures) > 0 {
+		if err := conn.Session(func(session *db.Session) error {
+			return LoadFixtures(session, options.fixtures...)
+		}); err != nil {
+			t.Fatal("dbtest:", err)
+		}
+	}
+	return &preparedConnection{Connection: conn}
+}
+
+// Tx opens a transaction that is rolled back on test cleanup. Until then sessions of a connection created by
+// NewConnection join the transaction, so services injected via Application work on the same rolled back data.
+func Tx(t testing.TB, conn db.Connection) *db.Session {
+	t.Helper()
+
+	prepared, isPrepared := conn.(*preparedConnection)
+	if isPrepared {
+		conn = prepared.Connection
+	}
+
+	sessionCh := make(chan *db.Session)
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- conn.Session(func(session *db.Session) error {
+			return session.Tx(func(session *db.Session) error {
+				sessionCh <- session
+				<-done
+				return errRollback
+			})
+		})
+	}()
+
+	select {
+	case session := <-sessionCh:
+		if isPrepared {
+			prepared.tx.Store(session)
+		}
+		t.Cleanup(func() {
+			if isPrepared {
+				prepared.tx.Store(nil)
+			}
+			close(done)
+			if err := <-result; !errors.Is(err, errRollback) {
+				t.Error("dbtest: on rollback:", err)
+			}
+		})
+		return session
+	case err := <-result:
+		t.Fatal("dbtest: can't open transaction:", err)
+		return nil
+	}
+}
+
+// Application replaces the connection injected by interface type (inject:"") with conn. A connection injected by its
+// service name (inject:"orders") is not replaced, use NamedApplication for it.
+func Application(conn db.Connection, packages ...ctx.ServicePackage) ctx_testing.TestingApplication {
+	return ctx_testing.CreateTestingApplication(packages...).WithTestingService(ctx_testing.Instead[db.Connection](conn))
+}
+
+// NamedApplication replaces both the connection injected by interface type and the one registered under name with conn.
+func NamedApplication(name string, conn db.Connection, packages ...ctx.ServicePackage) ctx_testing.TestingApplication {
+	return Application(conn, packages...).WithTestingService(ctx.WithName(name, conn))
+}
+
+type preparedConnection struct {
+	db.Connection
+	tx atomic.Pointer[db.Session]
+}
+
+func (c *preparedConnection) Init() {
+}
+
+func (c *preparedConnection) Session(dbFunc func(session *db.Session) error) error {
+	return c.SessionContext(context.Background(), dbFunc)
+}
+
+func (c *preparedConnection) SessionContext(baseContext context.Context, dbFunc func(session *db.Session) error) error {
+	if session := c.tx.Load(); session != nil {
+		return dbFunc(session.WithBaseContext(baseContext))
+	}
+	return c.Connection.SessionContext(baseContext, dbFunc)
+}
+
+func (c *preparedConnection) ReadSession(dbFunc func(session *db.Session) error) error {
+	return c.ReadSessionContext(context.Background(), dbFunc)
+}
+
+func (c *preparedConnection) ReadSessionContext(baseContext context.Context, dbFunc func(session *db.Session) error) error {
+	if session := c.tx.Load(); session != nil {
+		return dbFunc(session.WithBaseContext(baseContext))
+	}
+	return c.Connection.ReadSessionContext(baseContext, dbFunc)
+}
+
+func (c *preparedConnection) Close() error {
+	return c.Connection.(io.Closer).Close()
+}
@@ -0,0 +1,169 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"github.com/sedmess/go-ctx-base/db"
+	"github.com/sedmess/go-ctx/ctx"
+	"testing"
+)
+
+type Account struct {
+	Id      int64 `gorm:"primaryKey"`
+	Owner   string
+	Balance int
+}
+
+type Transfer struct {
+	Id     int64 `gorm:"primaryKey"`
+	FromId int64
+	ToId   int64
+	Amount int
+}
+
+type accountService struct {
+	db db.Connection `inject:""`
+}
+
+func (s *accountService) Balance(owner string) (int, error) {
+	return db.SessionReturning(s.db, func(session *db.Session) (int, error) {
+		var account Account
+		err := session.Where("owner = ?", owner).Take(&account).Error
+		return account.Balance, err
+	})
+}
+
+type ordersService struct {
+	db db.Connection `inject:"orders"`
+}
+
+func newTestConnection(t *testing.T) db.Connection {
+	return NewConnection(t, WithModels(&Account{}, &Transfer{}), WithFixtures("testdata/accounts.yaml", "testdata/transfers.json"))
+}
+
+func Test_Fixtures(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	conn := newTestConnection(t)
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		var accounts []Account
+		gm.Expect(session.Order("id").Find(&accounts).Error).Should(gm.BeNil())
+		gm.Expect(accounts).Should(gm.Equal([]Account{{Id: 1, Owner: "alice", Balance: 100}, {Id: 2, Owner: "bob", Balance: 50}}))
+		var transfer Transfer
+		gm.Expect(session.First(&transfer).Error).Should(gm.BeNil())
+		gm.Expect(transfer).Should(gm.Equal(Transfer{Id: 1, FromId: 1, ToId: 2, Amount: 10}))
+		return nil
+	})).Should(gm.BeNil())
+}
+
+func Test_Isolation(t *testing.T) {
+	for _, name := range []string{"first", "second", "third"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			g := gm.NewWithT(t)
+
+			conn := newTestConnection(t)
+			g.Expect(conn.Session(func(session *db.Session) error {
+				return session.Create(&Account{Id: 3, Owner: name}).Error
+			})).Should(gm.BeNil())
+			g.Expect(conn.Session(func(session *db.Session) error {
+				var count int64
+				g.Expect(session.Model(&Account{}).Count(&count).Error).Should(gm.BeNil())
+				g.Expect(count).Should(gm.Equal(int64(3)))
+				return nil
+			})).Should(gm.BeNil())
+		})
+	}
+}
+
+func Test_Rollback(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	conn := newTestConnection(t)
+	t.Run("changes", func(t *testing.T) {
+		session := Tx(t, conn)
+		gm.Expect(session.Model(&Account{}).Where("owner = ?", "alice").Update("balance", 0).Error).Should(gm.BeNil())
+		gm.Expect(session.Delete(&Transfer{Id: 1}).Error).Should(gm.BeNil())
+	})
+	t.Run("restored", func(t *testing.T) {
+		session := Tx(t, conn)
+		var account Account
+		gm.Expect(session.Where("owner = ?", "alice").Take(&account).Error).Should(gm.BeNil())
+		gm.Expect(account.Balance).Should(gm.Equal(100))
+		var count int64
+		gm.Expect(session.Model(&Transfer{}).Count(&count).Error).Should(gm.BeNil())
+		gm.Expect(count).Should(gm.Equal(int64(1)))
+	})
+}
+
+func Test_Application(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	service := &accountService{}
+	Application(newTestConnection(t), ctx.PackageOf(service)).Run(func() int {
+		balance, err := service.Balance("bob")
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(balance).Should(gm.Equal(50))
+		return 0
+	})
+}
+
+func Test_NamedApplication(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	conn := newTestConnection(t)
+	service := &accountService{}
+	orders := &ordersService{}
+	NamedApplication("orders", conn, ctx.PackageOf(db.NewConnection("orders", "ORDERS", false, false), service, orders)).Run(func() int {
+		gm.Expect(orders.db).Should(gm.BeIdenticalTo(conn))
+		gm.Expect(service.db).Should(gm.BeIdenticalTo(conn))
+		return 0
+	})
+}
+
+func Test_ApplicationTx(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	conn := newTestConnection(t)
+	service := &accountService{}
+	t.Run("changes", func(t *testing.T) {
+		session := Tx(t, conn)
+		gm.Expect(session.Model(&Account{}).Where("owner = ?", "bob").Update("balance", 0).Error).Should(gm.BeNil())
+		Application(conn, ctx.PackageOf(service)).Run(func() int {
+			balance, err := service.Balance("bob")
+			gm.Expect(err).Should(gm.BeNil())
+			gm.Expect(balance).Should(gm.Equal(0))
+			return 0
+		})
+	})
+	balance, err := service.Balance("bob")
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(balance).Should(gm.Equal(50))
+}
+
+func Test_TxBaseContext(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	conn := newTestConnection(t)
+	Tx(t, conn)
+
+	cancelled, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	err := conn.SessionContext(cancelled, func(session *db.Session) error {
+		gm.Expect(session.InTx()).Should(gm.BeTrue())
+		gm.Expect(session.Context).Should(gm.Equal(cancelled))
+		var account Account
+		return session.Take(&account).Error
+	})
+	gm.Expect(errors.Is(err, context.Canceled)).Should(gm.BeTrue())
+
+	err = conn.ReadSessionContext(db.WithQueryTimeout(context.Background(), 0), func(session *db.Session) error {
+		_, hasDeadline := session.Deadline()
+		gm.Expect(hasDeadline).Should(gm.BeFalse())
+		var account Account
+		return session.Take(&account).Error
+	})
+	gm.Expect(err).Should(gm.BeNil())
+}
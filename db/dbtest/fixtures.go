@@ -0,0 +1,51 @@
+package dbtest
+
+import (
+	"fmt"
+	"github.com/sedmess/go-ctx-base/db"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+func LoadFixtures(session *db.Session, paths ...string) error {
+	return session.Tx(func(session *db.Session) error {
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("fixture %s: %w", path, err)
+			}
+			if err := loadFixture(session, data); err != nil {
+				return fmt.Errorf("fixture %s: %w", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+func loadFixture(session *db.Session, data []byte) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	tables := document.Content[0]
+	if tables.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected mapping of table names to rows at line %d", tables.Line)
+	}
+	for i := 0; i+1 < len(tables.Content); i += 2 {
+		table := tables.Content[i].Value
+		var rows []map[string]any
+		if err := tables.Content[i+1].Decode(&rows); err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		if err := session.Table(table).Create(&rows).Error; err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+	}
+	return nil
+}
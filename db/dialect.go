@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/glebarez/sqlite"
+	gomysql "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DriverPostgres  = "postgres"
+	DriverSQLite    = "sqlite"
+	DriverMySQL     = "mysql"
+	DriverSQLServer = "sqlserver"
+)
+
+const sqlServerUpdateLockHint = "WITH (UPDLOCK, ROWLOCK)"
+const sqlServerSkipLockedHint = "WITH (UPDLOCK, READPAST, ROWLOCK)"
+
+type dsnSettings struct {
+	host     string
+	port     int
+	user     string
+	password string
+	name     string
+	sslMode  string
+	timeZone string
+}
+
+type dialect struct {
+	title        string
+	open         func(dsn string) gorm.Dialector
+	openConn     func(conn gorm.ConnPool) gorm.Dialector
+	newConnector func(dsn string, credentials Credentials) (driver.Connector, error)
+	buildDsn     func(settings dsnSettings) string
+	checkQuery   string
+}
+
+var dialects = map[string]*dialect{
+	DriverPostgres: {
+		title: "Postgres",
+		open:  postgres.Open,
+		openConn: func(conn gorm.ConnPool) gorm.Dialector {
+			return postgres.New(postgres.Config{Conn: conn})
+		},
+		newConnector: postgresConnector,
+		buildDsn:     postgresDsn,
+		checkQuery:   "select null",
+	},
+	DriverSQLite: {
+		title:      "SQLite",
+		open:       sqlite.Open,
+		checkQuery: "select null",
+	},
+	DriverMySQL: {
+		title: "MySQL",
+		open:  mysql.Open,
+		openConn: func(conn gorm.ConnPool) gorm.Dialector {
+			return mysql.New(mysql.Config{Conn: conn})
+		},
+		newConnector: mysqlConnector,
+		buildDsn:     mysqlDsn,
+		checkQuery:   "SELECT 1",
+	},
+	DriverSQLServer: {
+		title: "SQL Server",
+		open:  sqlserver.Open,
+		openConn: func(conn gorm.ConnPool) gorm.Dialector {
+			return sqlserver.New(sqlserver.Config{Conn: conn})
+		},
+		newConnector: sqlServerConnector,
+		buildDsn:     sqlServerDsn,
+		checkQuery:   "SELECT 1",
+	},
+}
+
+func postgresDsn(settings dsnSettings) string {
+	sslMode := settings.sslMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	dsn := fmt.Sprintf(dbDsnPattern, settings.host, settings.port, settings.user, settings.password, settings.name, sslMode)
+	if settings.timeZone != "" {
+		dsn += fmt.Sprintf(dbDsnTimeZonePatternAddition, settings.timeZone)
+	}
+	return dsn
+}
+
+func mysqlDsn(settings dsnSettings) string {
+	config := gomysql.NewConfig()
+	config.User = settings.user
+	config.Passwd = settings.password
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(settings.host, strconv.Itoa(settings.port))
+	config.DBName = settings.name
+	config.ParseTime = true
+	config.Params = map[string]string{"charset": "utf8mb4"}
+	if settings.sslMode != "" && settings.sslMode != "disable" {
+		config.TLSConfig = "true"
+	}
+	if settings.timeZone != "" {
+		if location, err := time.LoadLocation(settings.timeZone); err == nil {
+			config.Loc = location
+		}
+	}
+	return config.FormatDSN()
+}
+
+func sqlServerDsn(settings dsnSettings) string {
+	query := url.Values{}
+	query.Set("database", settings.name)
+	if settings.sslMode == "disable" {
+		query.Set("encrypt", "disable")
+	} else if settings.sslMode != "" {
+		query.Set("encrypt", "true")
+	}
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(settings.user, settings.password),
+		Host:     net.JoinHostPort(settings.host, strconv.Itoa(settings.port)),
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+func checkConnection(context context.Context, db *gorm.DB) error {
+	checkQuery := dialects[DriverPostgres].checkQuery
+	if d, found := dialects[db.Dialector.Name()]; found {
+		checkQuery = d.checkQuery
+	}
+	rows, err := db.ConnPool.QueryContext(context, checkQuery)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+type tableHint string
+
+func (h tableHint) ModifyStatement(stmt *gorm.Statement) {
+	fromClause := stmt.Clauses["FROM"]
+	if fromClause.Expression == nil {
+		fromClause.Expression = clause.From{}
+	}
+	fromClause.Name = "FROM"
+	if fromClause.AfterExpression != nil {
+		fromClause.AfterExpression = clause.Expr{SQL: "? " + string(h), Vars: []any{fromClause.AfterExpression}}
+	} else {
+		fromClause.AfterExpression = clause.Expr{SQL: string(h)}
+	}
+	stmt.Clauses["FROM"] = fromClause
+}
+
+func (h tableHint) Build(clause.Builder) {
+}
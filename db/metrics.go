@@ -0,0 +1,219 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const metricsPluginName = "ctx_base:metrics"
+const metricsStartKey = "ctx_base:metrics_start"
+
+const primaryPoolName = "primary"
+const sqliteReaderPoolName = "sqlite-reader"
+
+const (
+	OperationCreate = "create"
+	OperationQuery  = "query"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	OperationRaw    = "raw"
+)
+
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type Histogram struct {
+	Buckets []time.Duration
+	Counts  []uint64
+	Sum     time.Duration
+}
+
+type QueryMetrics struct {
+	Operation string
+	Table     string
+	Count     uint64
+	Errors    uint64
+	Latency   Histogram
+}
+
+type PoolMetrics struct {
+	WaitCount    int64
+	WaitDuration time.Duration
+	Stats        sql.DBStats
+}
+
+type Metrics struct {
+	Queries []QueryMetrics
+	// Pool sums the stats of all pools; Pools reports each of them by name: primary, sqlite-reader and the replicas
+	Pool  PoolMetrics
+	Pools map[string]PoolMetrics
+}
+
+type queryMetricsKey struct {
+	operation string
+	table     string
+}
+
+type queryMetrics struct {
+	count   atomic.Uint64
+	errors  atomic.Uint64
+	sum     atomic.Int64
+	buckets []atomic.Uint64
+}
+
+type metricsPlugin struct {
+	mu      sync.RWMutex
+	queries map[queryMetricsKey]*queryMetrics
+}
+
+func newMetricsPlugin() *metricsPlugin {
+	return &metricsPlugin{queries: make(map[queryMetricsKey]*queryMetrics)}
+}
+
+func (p *metricsPlugin) Name() string {
+	return metricsPluginName
+}
+
+func (p *metricsPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		name      string
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", OperationCreate, callback.Create().Before("gorm:create").Register, callback.Create().After("gorm:create").Register},
+		{"query", OperationQuery, callback.Query().Before("gorm:query").Register, callback.Query().After("gorm:query").Register},
+		{"update", OperationUpdate, callback.Update().Before("gorm:update").Register, callback.Update().After("gorm:update").Register},
+		{"delete", OperationDelete, callback.Delete().Before("gorm:delete").Register, callback.Delete().After("gorm:delete").Register},
+		{"row", OperationQuery, callback.Row().Before("gorm:row").Register, callback.Row().After("gorm:row").Register},
+		{"raw", OperationRaw, callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+	for _, processor := range processors {
+		operation := processor.operation
+		if err := processor.before(metricsPluginName+"_before_"+processor.name, p.before); err != nil {
+			return err
+		}
+		if err := processor.after(metricsPluginName+"_after_"+processor.name, func(db *gorm.DB) { p.after(operation, db) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *metricsPlugin) before(db *gorm.DB) {
+	db.InstanceSet(metricsStartKey, time.Now())
+}
+
+func (p *metricsPlugin) after(operation string, db *gorm.DB) {
+	value, found := db.InstanceGet(metricsStartKey)
+	if !found {
+		return
+	}
+	elapsed := time.Since(value.(time.Time))
+	failed := db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)
+	p.observe(queryMetricsKey{operation: operation, table: db.Statement.Table}, elapsed, failed)
+}
+
+func (p *metricsPlugin) observe(key queryMetricsKey, elapsed time.Duration, failed bool) {
+	p.mu.RLock()
+	metrics, found := p.queries[key]
+	p.mu.RUnlock()
+	if !found {
+		p.mu.Lock()
+		if metrics, found = p.queries[key]; !found {
+			metrics = &queryMetrics{buckets: make([]atomic.Uint64, len(LatencyBuckets)+1)}
+			p.queries[key] = metrics
+		}
+		p.mu.Unlock()
+	}
+
+	metrics.count.Add(1)
+	if failed {
+		metrics.errors.Add(1)
+	}
+	metrics.sum.Add(int64(elapsed))
+	bucket := sort.Search(len(LatencyBuckets), func(i int) bool { return elapsed <= LatencyBuckets[i] })
+	metrics.buckets[bucket].Add(1)
+}
+
+func (p *metricsPlugin) snapshot() []QueryMetrics {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]QueryMetrics, 0, len(p.queries))
+	for key, metrics := range p.queries {
+		counts := make([]uint64, len(metrics.buckets))
+		for i := range metrics.buckets {
+			counts[i] = metrics.buckets[i].Load()
+		}
+		result = append(result, QueryMetrics{
+			Operation: key.operation,
+			Table:     key.table,
+			Count:     metrics.count.Load(),
+			Errors:    metrics.errors.Load(),
+			Latency:   Histogram{Buckets: LatencyBuckets, Counts: counts, Sum: time.Duration(metrics.sum.Load())},
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Operation != result[j].Operation {
+			return result[i].Operation < result[j].Operation
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
+
+func (instance *connection) Metrics() Metrics {
+	metrics := Metrics{Queries: instance.metrics.snapshot(), Pools: make(map[string]PoolMetrics)}
+	addPool := func(name string, db *gorm.DB) {
+		if sqlDb, err := db.DB(); err == nil {
+			stats := sqlDb.Stats()
+			metrics.Pools[name] = PoolMetrics{WaitCount: stats.WaitCount, WaitDuration: stats.WaitDuration, Stats: stats}
+			metrics.Pool.add(stats)
+		}
+	}
+	if db, err := instance.primary(); err == nil {
+		addPool(primaryPoolName, db)
+	}
+	if instance.sqliteReader != nil {
+		addPool(sqliteReaderPoolName, instance.sqliteReader)
+	}
+	if instance.replicas != nil {
+		for _, r := range instance.replicas.replicas {
+			addPool(r.name, r.db)
+		}
+	}
+	return metrics
+}
+
+func (m *PoolMetrics) add(stats sql.DBStats) {
+	m.WaitCount += stats.WaitCount
+	m.WaitDuration += stats.WaitDuration
+	m.Stats.MaxOpenConnections += stats.MaxOpenConnections
+	m.Stats.OpenConnections += stats.OpenConnections
+	m.Stats.InUse += stats.InUse
+	m.Stats.Idle += stats.Idle
+	m.Stats.WaitCount += stats.WaitCount
+	m.Stats.WaitDuration += stats.WaitDuration
+	m.Stats.MaxIdleClosed += stats.MaxIdleClosed
+	m.Stats.MaxIdleTimeClosed += stats.MaxIdleTimeClosed
+	m.Stats.MaxLifetimeClosed += stats.MaxLifetimeClosed
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	gm "github.com/onsi/gomega"
+	"os"
+	"testing"
+)
+
+type meteredItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+func Test_Metrics(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("METRICS_TEST_DB_SQLITE_PATH", "file:metrics_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("metrics_test", "metrics_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&meteredItem{})
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		if err := session.Create(&meteredItem{Id: 1, Name: "first"}).Error; err != nil {
+			return err
+		}
+		var items []meteredItem
+		if err := session.Find(&items).Error; err != nil {
+			return err
+		}
+		if err := session.Model(&meteredItem{Id: 1}).Update("name", "updated").Error; err != nil {
+			return err
+		}
+		_ = session.Create(&meteredItem{Id: 1, Name: "duplicate"}).Error
+		if err := session.Exec("update metered_items set name = ?", "raw").Error; err != nil {
+			return err
+		}
+		return session.Delete(&meteredItem{Id: 1}).Error
+	})).Should(gm.BeNil())
+
+	byOperation := make(map[string]QueryMetrics)
+	for _, metrics := range conn.Metrics().Queries {
+		if metrics.Table == "metered_items" || metrics.Operation == OperationRaw {
+			byOperation[metrics.Operation] = metrics
+		}
+	}
+	gm.Expect(byOperation[OperationCreate].Count).Should(gm.Equal(uint64(2)))
+	gm.Expect(byOperation[OperationCreate].Errors).Should(gm.Equal(uint64(1)))
+	gm.Expect(byOperation[OperationQuery].Count).Should(gm.Equal(uint64(1)))
+	gm.Expect(byOperation[OperationUpdate].Count).Should(gm.Equal(uint64(1)))
+	gm.Expect(byOperation[OperationDelete].Count).Should(gm.Equal(uint64(1)))
+	gm.Expect(byOperation[OperationRaw].Count).Should(gm.BeNumerically(">=", 1))
+
+	histogram := byOperation[OperationCreate].Latency
+	gm.Expect(histogram.Counts).Should(gm.HaveLen(len(LatencyBuckets) + 1))
+	total := uint64(0)
+	for _, count := range histogram.Counts {
+		total += count
+	}
+	gm.Expect(total).Should(gm.Equal(uint64(2)))
+	gm.Expect(conn.Metrics().Pool.Stats.MaxOpenConnections).Should(gm.BeNumerically(">=", 0))
+}
+
+func Test_PoolMetrics(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("POOL_METRICS_TEST_DB_SQLITE_PATH", "file:pool_metrics_test:?mode=memory&cache=shared")
+	_ = os.Setenv("POOL_METRICS_TEST_DB_SQLITE_SINGLE_WRITER", "true")
+	_ = os.Setenv("POOL_METRICS_TEST_DB_REPLICA_DSNS", "file:pool_metrics_test_r0:?mode=memory&cache=shared")
+
+	conn := NewConnection("pool_metrics_test", "pool_metrics_test", false, false)
+	conn.Init()
+	gm.Expect(conn.ReadSession(func(session *Session) error {
+		return session.Exec("select 1").Error
+	})).Should(gm.BeNil())
+
+	metrics := conn.Metrics()
+	gm.Expect(metrics.Pools).Should(gm.HaveLen(3))
+	gm.Expect(metrics.Pools).Should(gm.HaveKey("primary"))
+	gm.Expect(metrics.Pools).Should(gm.HaveKey("sqlite-reader"))
+	gm.Expect(metrics.Pools).Should(gm.HaveKey("replica-0"))
+	gm.Expect(metrics.Pools["primary"].Stats.MaxOpenConnections).Should(gm.Equal(1))
+
+	openConnections := 0
+	for _, pool := range metrics.Pools {
+		openConnections += pool.Stats.OpenConnections
+	}
+	gm.Expect(openConnections).Should(gm.BeNumerically(">", 0))
+	gm.Expect(metrics.Pool.Stats.OpenConnections).Should(gm.Equal(openConnections))
+}
@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx-base/db"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	commandUp     = "up"
+	commandDown   = "down"
+	commandStatus = "status"
+)
+
+var ErrUnknownCommand = errors.New("unknown migrate command")
+
+func Run(connection db.Connection, migrator *Migrator, args ...string) error {
+	return RunTo(os.Stdout, connection, migrator, args...)
+}
+
+func RunTo(out io.Writer, connection db.Connection, migrator *Migrator, args ...string) error {
+	command := commandUp
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	return connection.Session(func(session *db.Session) error {
+		switch command {
+		case commandUp:
+			count, err := migrator.Up(session)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(out, "applied", count, "migrations")
+			return nil
+		case commandDown:
+			steps := 1
+			if len(args) > 1 {
+				var err error
+				if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+					return fmt.Errorf("invalid number of steps: %s", args[1])
+				}
+			}
+			count, err := migrator.Down(session, steps)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(out, "reverted", count, "migrations")
+			return nil
+		case commandStatus:
+			statuses, err := migrator.Status(session)
+			if err != nil {
+				return err
+			}
+			for _, status := range statuses {
+				if status.Applied {
+					_, _ = fmt.Fprintf(out, "%d\t%s\tapplied at %s\n", status.Version, status.Name, status.AppliedAt.Format("2006-01-02 15:04:05"))
+				} else {
+					_, _ = fmt.Fprintf(out, "%d\t%s\tpending\n", status.Version, status.Name)
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx-base/db"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const upSuffix = ".up.sql"
+const downSuffix = ".down.sql"
+
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       func(session *db.Session) error
+	Down     func(session *db.Session) error
+	Checksum string
+}
+
+func SQL(version int64, name string, up string, down string) Migration {
+	migration := Migration{Version: version, Name: name, Up: execSQL(up), Checksum: checksum(up)}
+	if down != "" {
+		migration.Down = execSQL(down)
+	}
+	return migration
+}
+
+func Func(version int64, name string, up func(session *db.Session) error, down func(session *db.Session) error) Migration {
+	return Migration{Version: version, Name: name, Up: up, Down: down}
+}
+
+func FromFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ups := make(map[int64]string)
+	downs := make(map[int64]string)
+	names := make(map[int64]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fileName := entry.Name()
+		var base string
+		var target map[int64]string
+		if strings.HasSuffix(fileName, upSuffix) {
+			base = strings.TrimSuffix(fileName, upSuffix)
+			target = ups
+		} else if strings.HasSuffix(fileName, downSuffix) {
+			base = strings.TrimSuffix(fileName, downSuffix)
+			target = downs
+		} else {
+			continue
+		}
+		version, name, err := parseFileName(base)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		if prevName, found := names[version]; found && prevName != name {
+			return nil, fmt.Errorf("%s: version %d already used by %s", fileName, version, prevName)
+		}
+		if _, found := target[version]; found {
+			return nil, fmt.Errorf("%s: duplicate migration version %d", fileName, version)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+		names[version] = name
+		target[version] = string(content)
+	}
+
+	migrations := make([]Migration, 0, len(ups))
+	for version, name := range names {
+		up, found := ups[version]
+		if !found {
+			return nil, fmt.Errorf("migration %d_%s has no %s file", version, name, upSuffix)
+		}
+		migrations = append(migrations, SQL(version, name, up, downs[version]))
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func parseFileName(base string) (int64, string, error) {
+	versionStr, name, _ := strings.Cut(base, "_")
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", errors.New("migration file name must start with a positive version number")
+	}
+	return version, name, nil
+}
+
+func checksum(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+func execSQL(sql string) func(session *db.Session) error {
+	statements := splitStatements(sql)
+	return func(session *db.Session) error {
+		for _, statement := range statements {
+			if err := session.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func splitStatements(sql string) []string {
+	statements := make([]string, 0)
+	var current strings.Builder
+	flush := func() {
+		statement := strings.TrimSpace(current.String())
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end
+				current.WriteByte('\n')
+			}
+			continue
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 3
+			}
+			current.WriteByte(' ')
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(sql[i+1:], c)
+			if end < 0 {
+				current.WriteString(sql[i:])
+				i = len(sql)
+			} else {
+				current.WriteString(sql[i : i+end+2])
+				i += end + 1
+			}
+			continue
+		case c == '$':
+			if tag, ok := dollarQuoteTag(sql[i:]); ok {
+				end := strings.Index(sql[i+len(tag):], tag)
+				if end < 0 {
+					current.WriteString(sql[i:])
+					i = len(sql)
+				} else {
+					current.WriteString(sql[i : i+end+2*len(tag)])
+					i += end + 2*len(tag) - 1
+				}
+				continue
+			}
+		case c == ';':
+			flush()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	flush()
+	return statements
+}
+
+func dollarQuoteTag(sql string) (string, bool) {
+	for i := 1; i < len(sql); i++ {
+		c := sql[i]
+		if c == '$' {
+			return sql[:i+1], true
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+	return "", false
+}
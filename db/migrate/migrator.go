@@ -0,0 +1,193 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx-base/db"
+	"github.com/sedmess/go-ctx/logger"
+	"sort"
+	"time"
+)
+
+const migrationsTable = "schema_migrations"
+
+var ErrChecksumMismatch = errors.New("applied migration was modified")
+var ErrNoDownMigration = errors.New("migration has no down step")
+
+type appliedMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	l          logger.Logger
+	migrations []Migration
+}
+
+func New(migrations ...Migration) (*Migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, migration := range sorted {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d_%s has no up step", migration.Version, migration.Name)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migration.Version)
+		}
+	}
+	return &Migrator{l: logger.NewWithTag("migrate"), migrations: sorted}, nil
+}
+
+func (m *Migrator) Migrate(session *db.Session) error {
+	_, err := m.Up(session)
+	return err
+}
+
+func (m *Migrator) Up(session *db.Session) (int, error) {
+	count := 0
+	err := m.locked(session, func() error {
+		applied, err := m.verify(session)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, found := applied[migration.Version]; found {
+				continue
+			}
+			m.l.Info("applying migration", migration.Version, migration.Name)
+			if err := session.Tx(func(session *db.Session) error {
+				if err := migration.Up(session); err != nil {
+					return err
+				}
+				return session.Table(migrationsTable).Create(&appliedMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					Checksum:  migration.Checksum,
+					AppliedAt: time.Now(),
+				}).Error
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	if err == nil {
+		m.l.Info("applied", count, "migrations")
+	}
+	return count, err
+}
+
+func (m *Migrator) Down(session *db.Session, steps int) (int, error) {
+	count := 0
+	err := m.locked(session, func() error {
+		applied, err := m.verify(session)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.migrations[i]
+			if _, found := applied[migration.Version]; !found {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, ErrNoDownMigration)
+			}
+			m.l.Info("reverting migration", migration.Version, migration.Name)
+			if err := session.Tx(func(session *db.Session) error {
+				if err := migration.Down(session); err != nil {
+					return err
+				}
+				return session.Table(migrationsTable).Delete(&appliedMigration{Version: migration.Version}).Error
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (m *Migrator) Status(session *db.Session) ([]Status, error) {
+	if err := m.ensureTable(session); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(session)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if record, found := applied[migration.Version]; found {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+func (m *Migrator) verify(session *db.Session) (map[int64]appliedMigration, error) {
+	applied, err := m.applied(session)
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range m.migrations {
+		record, found := applied[migration.Version]
+		if !found || migration.Checksum == "" || record.Checksum == "" {
+			continue
+		}
+		if record.Checksum != migration.Checksum {
+			return nil, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, ErrChecksumMismatch)
+		}
+	}
+	return applied, nil
+}
+
+func (m *Migrator) applied(session *db.Session) (map[int64]appliedMigration, error) {
+	var records []appliedMigration
+	if err := session.Table(migrationsTable).Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[int64]appliedMigration, len(records))
+	for _, record := range records {
+		result[record.Version] = record
+	}
+	return result, nil
+}
+
+func (m *Migrator) ensureTable(session *db.Session) error {
+	return session.Table(migrationsTable).AutoMigrate(&appliedMigration{})
+}
+
+func (m *Migrator) locked(session *db.Session, fn func() error) error {
+	unlock, err := db.AdvisoryLock(session, migrationsTable)
+	if err != nil && !errors.Is(err, db.ErrAdvisoryLockUnsupported) {
+		return err
+	}
+	if unlock != nil {
+		defer func() {
+			if err := unlock(); err != nil {
+				m.l.Error("on releasing migration lock:", err)
+			}
+		}()
+	}
+	if err := m.ensureTable(session); err != nil {
+		return err
+	}
+	return fn()
+}
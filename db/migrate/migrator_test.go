@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"errors"
+	gm "github.com/onsi/gomega"
+	"github.com/sedmess/go-ctx-base/db"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func Test_SplitStatements(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	statements := splitStatements(`
+-- comment; with semicolon
+create table a (id int, name text default 'x;y');
+/* block; comment */
+create function f() returns int as $body$ begin return 1; end $body$ language plpgsql;
+insert into a values (1, 'it''s');
+`)
+
+	gm.Expect(statements).Should(gm.HaveLen(3))
+	gm.Expect(statements[0]).Should(gm.Equal("create table a (id int, name text default 'x;y')"))
+	gm.Expect(statements[1]).Should(gm.HavePrefix("create function f()"))
+	gm.Expect(statements[1]).Should(gm.HaveSuffix("language plpgsql"))
+	gm.Expect(statements[2]).Should(gm.Equal("insert into a values (1, 'it''s')"))
+}
+
+func Test_Migrator(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("MIGRATE_TEST_DB_SQLITE_PATH", "file:migrate_test:?mode=memory&cache=shared")
+
+	fsys := fstest.MapFS{
+		"migrations/0001_create_items.up.sql":   {Data: []byte("create table items (id integer primary key, name text);")},
+		"migrations/0001_create_items.down.sql": {Data: []byte("drop table items;")},
+		"migrations/0002_add_price.up.sql":      {Data: []byte("alter table items add column price integer; insert into items (name, price) values ('a', 1);")},
+		"migrations/0002_add_price.down.sql":    {Data: []byte("alter table items drop column price;")},
+	}
+	migrations, err := FromFS(fsys, "migrations")
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(migrations).Should(gm.HaveLen(2))
+
+	migrator, err := New(migrations...)
+	gm.Expect(err).Should(gm.BeNil())
+
+	conn := db.NewConnection("migrate_test", "migrate_test", false, false, db.WithMigrationRunner(migrator))
+	conn.Init()
+
+	var count int64
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		return session.Table("items").Where("price = ?", 1).Count(&count).Error
+	})).Should(gm.BeNil())
+	gm.Expect(count).Should(gm.Equal(int64(1)))
+
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		applied, err := migrator.Up(session)
+		gm.Expect(applied).Should(gm.Equal(0))
+		return err
+	})).Should(gm.BeNil())
+
+	gm.Expect(Run(conn, migrator, "down", "1")).Should(gm.BeNil())
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		statuses, err := migrator.Status(session)
+		gm.Expect(statuses).Should(gm.HaveLen(2))
+		gm.Expect(statuses[0].Applied).Should(gm.BeTrue())
+		gm.Expect(statuses[1].Applied).Should(gm.BeFalse())
+		return err
+	})).Should(gm.BeNil())
+
+	modified, err := New(SQL(1, "create_items", "create table items (id integer primary key);", ""))
+	gm.Expect(err).Should(gm.BeNil())
+	err = Run(conn, modified, "up")
+	gm.Expect(errors.Is(err, ErrChecksumMismatch)).Should(gm.BeTrue())
+}
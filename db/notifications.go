@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/sedmess/go-ctx-base/utils/channels"
+	"time"
+)
+
+var ErrNotificationsUnsupported = errors.New("LISTEN/NOTIFY requires a Postgres connection")
+
+type Notification struct {
+	Channel string
+	Payload string
+	PID     uint32
+}
+
+func Listen(ctx context.Context, conn Connection, channelNames ...string) channels.StreamingChan[Notification] {
+	instance, ok := conn.(*connection)
+	if !ok || instance.info.Driver != DriverPostgres {
+		return channels.SingleElemChannelErr(Notification{}, ErrNotificationsUnsupported)
+	}
+	return channels.CreateChannel(func(sink func(data Notification, context context.Context) bool) error {
+		return instance.listen(ctx, channelNames, sink)
+	})
+}
+
+func Notify(session *Session, channel string, payload string) error {
+	if session.Dialector.Name() != DriverPostgres {
+		return ErrNotificationsUnsupported
+	}
+	return session.Tx(func(session *Session) error {
+		return session.Exec("SELECT pg_notify(?, ?)", channel, payload).Error
+	})
+}
+
+// listen gives up after connectPolicy.MaxAttempts failed attempts until the first subscription succeeds, and keeps
+// resubscribing after that
+func (instance *connection) listen(ctx context.Context, channelNames []string, sink func(data Notification, context context.Context) bool) error {
+	subscribed := false
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(instance.connectPolicy.Backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+		}
+
+		listenConn, err := instance.listenConn(ctx, channelNames)
+		if err == nil {
+			attempt = 0
+			subscribed = true
+			err = receiveNotifications(ctx, listenConn, sink)
+			_ = listenConn.Close(context.Background())
+			if err == nil {
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if subscribed {
+			instance.logger.Error("LISTEN connection lost, resubscribing:", err)
+		} else if attempt+1 >= instance.connectPolicy.MaxAttempts {
+			return fmt.Errorf("can't LISTEN after %d attempts: %w", attempt+1, err)
+		} else {
+			instance.logger.Error("can't LISTEN, retrying:", err)
+		}
+	}
+}
+
+func (instance *connection) listenConn(ctx context.Context, channelNames []string) (*pgx.Conn, error) {
+	config, err := pgx.ParseConfig(instance.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if instance.credentialProvider != nil {
+		credentials, err := instance.credentialProvider.Credentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if config, err = postgresConfig(instance.dsn, credentials); err != nil {
+			return nil, err
+		}
+	}
+	listenConn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	for _, channelName := range channelNames {
+		if _, err := listenConn.Exec(ctx, "LISTEN "+pgx.Identifier{channelName}.Sanitize()); err != nil {
+			_ = listenConn.Close(context.Background())
+			return nil, err
+		}
+	}
+	instance.logger.Debug("listening to", channelNames)
+	return listenConn, nil
+}
+
+func receiveNotifications(ctx context.Context, listenConn *pgx.Conn, sink func(data Notification, context context.Context) bool) error {
+	for {
+		notification, err := listenConn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !sink(Notification{Channel: notification.Channel, Payload: notification.Payload, PID: notification.PID}, ctx) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"net/http"
+	"reflect"
+)
+
+const versionTagSetting = "VERSION"
+
+var ErrStaleObject error = &statusError{message: "stale object: row was modified or deleted concurrently", status: http.StatusConflict}
+var ErrNotVersioned = errors.New("model has no version field")
+var ErrMissingPrimaryKey = errors.New("versioned model has no primary key value")
+
+// statusError carries the HTTP status an error maps to, so HTTP handlers can report it without importing db
+type statusError struct {
+	message string
+	status  int
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func (e *statusError) HTTPStatus() int {
+	return e.status
+}
+
+type Versioned struct {
+	Version int64 `gorm:"version;not null;default:1"`
+}
+
+func SaveVersioned(session *Session, model any) error {
+	v, err := resolveVersion(session, model)
+	if err != nil {
+		return err
+	}
+	if v.current == 0 {
+		if err := v.set(1); err != nil {
+			return err
+		}
+		if err := session.Create(model).Error; err != nil {
+			return errors.Join(err, v.set(0))
+		}
+		return nil
+	}
+	if err := v.requirePrimaryKey(); err != nil {
+		return err
+	}
+	if err := v.set(v.current + 1); err != nil {
+		return err
+	}
+	result := session.Model(model).Where(v.condition()).Select("*").Updates(model)
+	return v.complete(result)
+}
+
+func UpdateVersioned(session *Session, model any, updates map[string]any) error {
+	v, err := resolveVersion(session, model)
+	if err != nil {
+		return err
+	}
+	if err := v.requirePrimaryKey(); err != nil {
+		return err
+	}
+	values := make(map[string]any, len(updates)+1)
+	for column, value := range updates {
+		values[column] = value
+	}
+	values[v.field.DBName] = v.current + 1
+	result := session.Model(model).Where(v.condition()).Updates(values)
+	if err := v.complete(result); err != nil {
+		return err
+	}
+	return v.set(v.current + 1)
+}
+
+func DeleteVersioned(session *Session, model any) error {
+	v, err := resolveVersion(session, model)
+	if err != nil {
+		return err
+	}
+	if err := v.requirePrimaryKey(); err != nil {
+		return err
+	}
+	return v.complete(session.Where(v.condition()).Delete(model))
+}
+
+type versionedModel struct {
+	session *Session
+	stmt    *gorm.Statement
+	field   *schema.Field
+	value   reflect.Value
+	current int64
+}
+
+func resolveVersion(session *Session, model any) (*versionedModel, error) {
+	value := reflect.ValueOf(model)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("versioned model must be a pointer to struct, got %T", model)
+	}
+	stmt := &gorm.Statement{DB: session.DB}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	var field *schema.Field
+	for _, candidate := range stmt.Schema.Fields {
+		if _, found := candidate.TagSettings[versionTagSetting]; found {
+			field = candidate
+			break
+		}
+	}
+	if field == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotVersioned, stmt.Schema.Name)
+	}
+	v := &versionedModel{session: session, stmt: stmt, field: field, value: value.Elem()}
+	current, zero := field.ValueOf(session.Context, v.value)
+	if !zero {
+		switch number := reflect.ValueOf(current); number.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.current = number.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v.current = int64(number.Uint())
+		default:
+			return nil, fmt.Errorf("version field %s.%s must be an integer", stmt.Schema.Name, field.Name)
+		}
+	}
+	return v, nil
+}
+
+func (v *versionedModel) requirePrimaryKey() error {
+	if len(v.stmt.Schema.PrimaryFields) == 0 {
+		return fmt.Errorf("%w: %s", ErrMissingPrimaryKey, v.stmt.Schema.Name)
+	}
+	for _, field := range v.stmt.Schema.PrimaryFields {
+		if _, zero := field.ValueOf(v.session.Context, v.value); zero {
+			return fmt.Errorf("%w: %s.%s", ErrMissingPrimaryKey, v.stmt.Schema.Name, field.Name)
+		}
+	}
+	return nil
+}
+
+func (v *versionedModel) set(version int64) error {
+	return v.field.Set(v.session.Context, v.value, version)
+}
+
+func (v *versionedModel) condition() clause.Eq {
+	return clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: v.field.DBName}, Value: v.current}
+}
+
+func (v *versionedModel) complete(result *gorm.DB) error {
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = fmt.Errorf("%w: %s version %d", ErrStaleObject, v.stmt.Schema.Table, v.current)
+	}
+	if result.Error != nil {
+		if err := v.set(v.current); err != nil {
+			return errors.Join(result.Error, err)
+		}
+	}
+	return result.Error
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	gm "github.com/onsi/gomega"
+	"net/http"
+	"os"
+	"testing"
+)
+
+type versionedItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+	Versioned
+}
+
+type revisionedItem struct {
+	Id       int64 `gorm:"primaryKey"`
+	Name     string
+	Revision int32 `gorm:"version"`
+}
+
+type plainItem struct {
+	Id int64 `gorm:"primaryKey"`
+}
+
+func Test_OptimisticLock(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("OPTIMISTIC_TEST_DB_SQLITE_PATH", "file:optimistic_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("optimistic_test", "optimistic_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&versionedItem{}, &revisionedItem{}, &plainItem{})
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		item := &versionedItem{Id: 1, Name: "created"}
+		gm.Expect(SaveVersioned(session, item)).Should(gm.BeNil())
+		gm.Expect(item.Version).Should(gm.Equal(int64(1)))
+
+		var first, second versionedItem
+		gm.Expect(session.First(&first, 1).Error).Should(gm.BeNil())
+		gm.Expect(session.First(&second, 1).Error).Should(gm.BeNil())
+
+		first.Name = "first"
+		gm.Expect(SaveVersioned(session, &first)).Should(gm.BeNil())
+		gm.Expect(first.Version).Should(gm.Equal(int64(2)))
+
+		second.Name = "second"
+		err := SaveVersioned(session, &second)
+		gm.Expect(errors.Is(err, ErrStaleObject)).Should(gm.BeTrue())
+		gm.Expect(second.Version).Should(gm.Equal(int64(1)))
+
+		err = UpdateVersioned(session, &second, map[string]any{"name": "second"})
+		gm.Expect(errors.Is(err, ErrStaleObject)).Should(gm.BeTrue())
+
+		gm.Expect(UpdateVersioned(session, &first, map[string]any{"name": "updated"})).Should(gm.BeNil())
+		gm.Expect(first.Version).Should(gm.Equal(int64(3)))
+
+		var stored versionedItem
+		gm.Expect(session.First(&stored, 1).Error).Should(gm.BeNil())
+		gm.Expect(stored.Name).Should(gm.Equal("updated"))
+		gm.Expect(stored.Version).Should(gm.Equal(int64(3)))
+
+		err = UpdateVersioned(session, &versionedItem{Versioned: Versioned{Version: 3}}, map[string]any{"name": "clobbered"})
+		gm.Expect(errors.Is(err, ErrMissingPrimaryKey)).Should(gm.BeTrue())
+		err = SaveVersioned(session, &versionedItem{Name: "clobbered", Versioned: Versioned{Version: 3}})
+		gm.Expect(errors.Is(err, ErrMissingPrimaryKey)).Should(gm.BeTrue())
+		err = DeleteVersioned(session, &versionedItem{Versioned: Versioned{Version: 3}})
+		gm.Expect(errors.Is(err, ErrMissingPrimaryKey)).Should(gm.BeTrue())
+		gm.Expect(session.First(&stored, 1).Error).Should(gm.BeNil())
+		gm.Expect(stored.Name).Should(gm.Equal("updated"))
+
+		err = DeleteVersioned(session, &second)
+		gm.Expect(errors.Is(err, ErrStaleObject)).Should(gm.BeTrue())
+		gm.Expect(DeleteVersioned(session, &stored)).Should(gm.BeNil())
+
+		var count int64
+		gm.Expect(session.Model(&versionedItem{}).Count(&count).Error).Should(gm.BeNil())
+		gm.Expect(count).Should(gm.Equal(int64(0)))
+		return nil
+	})).Should(gm.BeNil())
+}
+
+func Test_OptimisticLockVersionTag(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("REVISION_TEST_DB_SQLITE_PATH", "file:revision_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("revision_test", "revision_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&revisionedItem{}, &plainItem{})
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		item := &revisionedItem{Id: 1, Name: "created"}
+		gm.Expect(SaveVersioned(session, item)).Should(gm.BeNil())
+		gm.Expect(item.Revision).Should(gm.Equal(int32(1)))
+
+		stale := *item
+		item.Name = "updated"
+		gm.Expect(session.Tx(func(session *Session) error {
+			return SaveVersioned(session, item)
+		})).Should(gm.BeNil())
+		gm.Expect(item.Revision).Should(gm.Equal(int32(2)))
+
+		err := session.Tx(func(session *Session) error {
+			return SaveVersioned(session, &stale)
+		})
+		gm.Expect(errors.Is(err, ErrStaleObject)).Should(gm.BeTrue())
+
+		err = SaveVersioned(session, &plainItem{Id: 1})
+		gm.Expect(errors.Is(err, ErrNotVersioned)).Should(gm.BeTrue())
+		return nil
+	})).Should(gm.BeNil())
+}
+
+func Test_StaleObjectHTTPStatus(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	var statusErr interface{ HTTPStatus() int }
+	gm.Expect(errors.As(fmt.Errorf("%w: items version 1", ErrStaleObject), &statusErr)).Should(gm.BeTrue())
+	gm.Expect(statusErr.HTTPStatus()).Should(gm.Equal(http.StatusConflict))
+}
@@ -0,0 +1,259 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx-base/db"
+	"github.com/sedmess/go-ctx-base/scheduler"
+	"github.com/sedmess/go-ctx/ctx/health"
+	"github.com/sedmess/go-ctx/logger"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const dispatcherLockKey = "outbox-dispatcher"
+
+type Handler func(context context.Context, message *Message) error
+
+type Dispatcher struct {
+	l      logger.Logger     `logger:""`
+	db     db.Connection     `inject:""`
+	locker *scheduler.Locker `inject:""`
+
+	pollInterval   time.Duration `env:"OUTBOX_POLL_INTERVAL" envDef:"1s"`
+	batchSize      int           `env:"OUTBOX_BATCH_SIZE" envDef:"100"`
+	maxAttempts    int           `env:"OUTBOX_MAX_ATTEMPTS" envDef:"10"`
+	initialBackoff time.Duration `env:"OUTBOX_INITIAL_BACKOFF" envDef:"1s"`
+	maxBackoff     time.Duration `env:"OUTBOX_MAX_BACKOFF" envDef:"10m"`
+	lagThreshold   time.Duration `env:"OUTBOX_LAG_THRESHOLD" envDef:"1m"`
+	leaseTimeout   time.Duration `env:"OUTBOX_LEASE_TIMEOUT" envDef:"5m"`
+
+	policy   db.RetryPolicy
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	cancelFn  context.CancelFunc
+	doneCh    chan bool
+	delivered atomic.Int64
+	failures  atomic.Int64
+	lastErr   atomic.Pointer[string]
+}
+
+func (instance *Dispatcher) Init() {
+	instance.handlers = make(map[string]Handler)
+	instance.policy = db.RetryPolicy{
+		MaxAttempts:    instance.maxAttempts,
+		InitialBackoff: instance.initialBackoff,
+		MaxBackoff:     instance.maxBackoff,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+	instance.db.AutoMigrate(&Message{})
+}
+
+func (instance *Dispatcher) AfterStart() {
+	var dispatchContext context.Context
+	dispatchContext, instance.cancelFn = context.WithCancel(context.Background())
+	instance.doneCh = make(chan bool)
+	go instance.run(dispatchContext)
+}
+
+func (instance *Dispatcher) BeforeStop() {
+	instance.cancelFn()
+	<-instance.doneCh
+}
+
+func (instance *Dispatcher) Handle(topic string, handler Handler) {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+
+	instance.handlers[topic] = handler
+}
+
+func (instance *Dispatcher) Health() health.ServiceHealth {
+	status := health.ServiceHealth{Status: health.Up, Details: make(map[string]any)}
+	status.Details["delivered"] = instance.delivered.Load()
+	status.Details["failures"] = instance.failures.Load()
+	if lastErr := instance.lastErr.Load(); lastErr != nil {
+		status.Details["lastError"] = *lastErr
+	}
+
+	err := instance.db.Session(func(session *db.Session) error {
+		var pending, inFlight, dead int64
+		if err := session.Model(&Message{}).Where("status = ?", StatusPending).Count(&pending).Error; err != nil {
+			return err
+		}
+		if err := session.Model(&Message{}).Where("status = ?", StatusInFlight).Count(&inFlight).Error; err != nil {
+			return err
+		}
+		if err := session.Model(&Message{}).Where("status = ?", StatusDead).Count(&dead).Error; err != nil {
+			return err
+		}
+		status.Details["pending"] = pending
+		status.Details["inFlight"] = inFlight
+		status.Details["dead"] = dead
+
+		lag := time.Duration(0)
+		if pending+inFlight > 0 {
+			var oldest Message
+			if err := session.Where("status in ?", []string{StatusPending, StatusInFlight}).Order("id").First(&oldest).Error; err != nil && !db.IsErrNotFound(err) {
+				return err
+			}
+			lag = time.Since(oldest.CreatedAt)
+		}
+		status.Details["lag"] = lag.String()
+
+		if dead > 0 || lag > instance.lagThreshold {
+			status.Status = health.Partially
+		}
+		return nil
+	})
+	if err != nil {
+		status.Status = health.Down
+		status.Details["error"] = err.Error()
+	}
+	return status
+}
+
+func (instance *Dispatcher) run(ctx context.Context) {
+	defer close(instance.doneCh)
+
+	ticker := time.NewTicker(instance.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			count, err := instance.dispatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					instance.l.Error("on dispatching outbox:", err)
+				}
+				break
+			}
+			if count < instance.batchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (instance *Dispatcher) dispatch(ctx context.Context) (int, error) {
+	return db.SessionContextReturning(ctx, instance.db, func(session *db.Session) (int, error) {
+		if session.Dialector.Name() == db.DriverSQLite {
+			lock, err := instance.locker.Lock(ctx, dispatcherLockKey)
+			if errors.Is(err, scheduler.ErrAlreadyLocked) {
+				return 0, nil
+			} else if err != nil {
+				return 0, err
+			}
+			defer func() { instance.l.LogIfError("unlock", lock.Unlock(ctx)) }()
+		}
+
+		messages, err := instance.claim(session)
+		if err != nil {
+			return 0, err
+		}
+		for i := range messages {
+			if ctx.Err() != nil {
+				instance.release(session, messages[i:])
+				return i, ctx.Err()
+			}
+			if err := instance.deliver(session, &messages[i]); err != nil {
+				instance.release(session, messages[i+1:])
+				return i, err
+			}
+		}
+		return len(messages), nil
+	})
+}
+
+// claim leases a batch of due messages and commits, so handlers run outside the claiming transaction;
+// a message whose lease expires without a recorded result is claimed again
+func (instance *Dispatcher) claim(session *db.Session) ([]Message, error) {
+	return db.TxReturning(session, func(session *db.Session) ([]Message, error) {
+		now := time.Now()
+		query := session.LockForUpdateSkipLocked().Where("status in ? and available_at <= ?", []string{StatusPending, StatusInFlight}, now).Order("id").Limit(instance.batchSize)
+		var messages []Message
+		if err := query.Find(&messages).Error; err != nil || len(messages) == 0 {
+			return nil, err
+		}
+		lease := map[string]any{"status": StatusInFlight, "available_at": now.Add(instance.leaseTimeout)}
+		if err := session.Model(&Message{}).Where("id in ?", messageIds(messages)).Updates(lease).Error; err != nil {
+			return nil, err
+		}
+		return messages, nil
+	})
+}
+
+// release returns claimed messages that were not handed to a handler back to the pending state
+func (instance *Dispatcher) release(session *db.Session, messages []Message) {
+	if len(messages) == 0 {
+		return
+	}
+	err := session.WithContext(context.Background()).Model(&Message{}).
+		Where("id in ? and status = ?", messageIds(messages), StatusInFlight).
+		Updates(map[string]any{"status": StatusPending, "available_at": time.Now()}).Error
+	instance.l.LogIfError("on releasing outbox messages", err)
+}
+
+func (instance *Dispatcher) deliver(session *db.Session, message *Message) error {
+	instance.mu.RLock()
+	handler, found := instance.handlers[message.Topic]
+	instance.mu.RUnlock()
+
+	var err error
+	if found {
+		err = instance.invoke(session.Context, handler, message)
+	} else {
+		err = errors.New("no handler registered for topic " + message.Topic)
+	}
+
+	now := time.Now()
+	var updates map[string]any
+	if err == nil {
+		instance.delivered.Add(1)
+		updates = map[string]any{"status": StatusDelivered, "delivered_at": now, "last_error": ""}
+	} else {
+		instance.failures.Add(1)
+		errStr := err.Error()
+		instance.lastErr.Store(&errStr)
+		attempts := message.Attempts + 1
+		updates = map[string]any{"attempts": attempts, "last_error": errStr}
+		if attempts >= instance.maxAttempts {
+			instance.l.Error("outbox message", message.Id, "of topic", message.Topic, "dead-lettered after", attempts, "attempts:", err)
+			updates["status"] = StatusDead
+		} else {
+			backoff := instance.policy.Backoff(attempts)
+			instance.l.Debug("outbox message", message.Id, "of topic", message.Topic, "will be retried in", backoff, ":", err)
+			updates["status"] = StatusPending
+			updates["available_at"] = now.Add(backoff)
+		}
+	}
+	return session.Tx(func(session *db.Session) error {
+		return session.Model(message).Where("status = ?", StatusInFlight).Updates(updates).Error
+	})
+}
+
+func (instance *Dispatcher) invoke(ctx context.Context, handler Handler, message *Message) (err error) {
+	defer func() {
+		if reason := recover(); reason != nil {
+			err = fmt.Errorf("handler panicked: %v", reason)
+		}
+	}()
+	return handler(ctx, message)
+}
+
+func messageIds(messages []Message) []int64 {
+	ids := make([]int64, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].Id
+	}
+	return ids
+}
@@ -0,0 +1,134 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"github.com/sedmess/go-ctx-base/db"
+	"github.com/sedmess/go-ctx-base/scheduler"
+	"github.com/sedmess/go-ctx/ctx"
+	"github.com/sedmess/go-ctx/ctx/ctx_testing"
+	"github.com/sedmess/go-ctx/ctx/health"
+	"github.com/sedmess/go-ctx/u"
+	"testing"
+)
+
+func Test_Dispatcher(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	dispatcher := &Dispatcher{}
+	ctx_testing.CreateTestingApplication(
+		ctx.PackageOf(
+			ctx.WithName(u.GetInterfaceName[db.Connection](), db.NewConnection("outbox_test", "outbox_test", false, false)),
+			&scheduler.Locker{},
+			dispatcher,
+		),
+	).
+		WithParameter("OUTBOX_TEST_DB_SQLITE_PATH", "file:outbox_test:?mode=memory&cache=shared").
+		WithParameter("OUTBOX_POLL_INTERVAL", "1h").
+		WithParameter("OUTBOX_MAX_ATTEMPTS", "2").
+		WithParameter("OUTBOX_INITIAL_BACKOFF", "0s").
+		Run(func() int {
+			testDispatcher(dispatcher)
+			testDispatcherPartialFailure(dispatcher)
+			return 0
+		})
+}
+
+func testDispatcher(dispatcher *Dispatcher) {
+	conn := dispatcher.db
+
+	delivered := make([]string, 0)
+	dispatcher.Handle("greetings", func(ctx context.Context, message *Message) error {
+		var text string
+		if err := message.Unmarshal(&text); err != nil {
+			return err
+		}
+		delivered = append(delivered, text)
+		return nil
+	})
+	dispatcher.Handle("broken", func(ctx context.Context, message *Message) error {
+		return errors.New("broken handler")
+	})
+
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		return Publish(session, "greetings", "outside")
+	})).Should(gm.Equal(ErrNotInTx))
+
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		return session.Tx(func(session *db.Session) error {
+			if err := Publish(session, "greetings", "hello"); err != nil {
+				return err
+			}
+			return Publish(session, "broken", map[string]int{"value": 1})
+		})
+	})).Should(gm.BeNil())
+
+	gm.Eventually(func() int {
+		count, err := dispatcher.dispatch(context.Background())
+		gm.Expect(err).Should(gm.BeNil())
+		return count
+	}).Should(gm.BeZero())
+
+	gm.Expect(delivered).Should(gm.Equal([]string{"hello"}))
+
+	status := dispatcher.Health()
+	gm.Expect(status.Status).Should(gm.Equal(health.Partially))
+	gm.Expect(status.Details["dead"]).Should(gm.Equal(int64(1)))
+	gm.Expect(status.Details["pending"]).Should(gm.Equal(int64(0)))
+}
+
+func testDispatcherPartialFailure(dispatcher *Dispatcher) {
+	conn := dispatcher.db
+
+	calls := make(map[string]int)
+	dispatcher.Handle("flaky", func(ctx context.Context, message *Message) error {
+		var text string
+		if err := message.Unmarshal(&text); err != nil {
+			return err
+		}
+		calls[text]++
+		_, inTx := ctx.(*db.Session)
+		gm.Expect(inTx).Should(gm.BeFalse())
+		if text == "second" && calls[text] == 1 {
+			return errors.New("flaky handler")
+		}
+		return nil
+	})
+
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		return session.Tx(func(session *db.Session) error {
+			for _, text := range []string{"first", "second", "third"} {
+				if err := Publish(session, "flaky", text); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})).Should(gm.BeNil())
+
+	count, err := dispatcher.dispatch(context.Background())
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(count).Should(gm.Equal(3))
+	gm.Expect(calls).Should(gm.Equal(map[string]int{"first": 1, "second": 1, "third": 1}))
+
+	var messages []Message
+	gm.Expect(conn.Session(func(session *db.Session) error {
+		return session.Where("topic = ?", "flaky").Order("id").Find(&messages).Error
+	})).Should(gm.BeNil())
+	gm.Expect(messages).Should(gm.HaveLen(3))
+	gm.Expect(messages[0].Status).Should(gm.Equal(StatusDelivered))
+	gm.Expect(messages[1].Status).Should(gm.Equal(StatusPending))
+	gm.Expect(messages[1].Attempts).Should(gm.Equal(1))
+	gm.Expect(messages[1].LastError).Should(gm.Equal("flaky handler"))
+	gm.Expect(messages[2].Status).Should(gm.Equal(StatusDelivered))
+
+	count, err = dispatcher.dispatch(context.Background())
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(count).Should(gm.Equal(1))
+	gm.Expect(calls).Should(gm.Equal(map[string]int{"first": 1, "second": 2, "third": 1}))
+
+	status := dispatcher.Health()
+	gm.Expect(status.Details["pending"]).Should(gm.Equal(int64(0)))
+	gm.Expect(status.Details["inFlight"]).Should(gm.Equal(int64(0)))
+}
@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sedmess/go-ctx-base/db"
+	"time"
+)
+
+const (
+	StatusPending   = "PENDING"
+	StatusInFlight  = "IN_FLIGHT"
+	StatusDelivered = "DELIVERED"
+	StatusDead      = "DEAD"
+)
+
+var ErrNotInTx = errors.New("outbox messages must be published inside a transaction")
+
+type Message struct {
+	Id          int64     `gorm:"primaryKey;autoIncrement"`
+	Topic       string    `gorm:"not null;index"`
+	Payload     []byte    `gorm:"not null"`
+	Status      string    `gorm:"not null;index:idx_outbox_messages_status_available,priority:1"`
+	// AvailableAt is the next delivery time of a pending message and the lease expiry of an in-flight one
+	AvailableAt time.Time `gorm:"not null;index:idx_outbox_messages_status_available,priority:2"`
+	Attempts    int       `gorm:"not null"`
+	LastError   string
+	CreatedAt   time.Time `gorm:"not null"`
+	DeliveredAt *time.Time
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+func (m *Message) Unmarshal(target any) error {
+	return json.Unmarshal(m.Payload, target)
+}
+
+func Publish(session *db.Session, topic string, payload any) error {
+	if !session.InTx() {
+		return ErrNotInTx
+	}
+	var data []byte
+	switch value := payload.(type) {
+	case []byte:
+		data = value
+	default:
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	return session.Create(&Message{
+		Topic:       topic,
+		Payload:     data,
+		Status:      StatusPending,
+		AvailableAt: now,
+		CreatedAt:   now,
+	}).Error
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	glogger "gorm.io/gorm/logger"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSlowQueryThreshold = 200 * time.Millisecond
+	defaultSlowQueryTopN      = 20
+	slowQuerySamples          = 128
+	slowQueryTrackedFactor    = 10
+)
+
+var logLevels = map[string]glogger.LogLevel{
+	"SILENT": glogger.Silent,
+	"ERROR":  glogger.Error,
+	"WARN":   glogger.Warn,
+	"INFO":   glogger.Info,
+}
+
+var (
+	sqlStringLiteralRegexp = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumberLiteralRegexp = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	sqlPlaceholderRegexp   = regexp.MustCompile(`\$\d+|@p\d+`)
+	sqlInListRegexp        = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	sqlWhitespaceRegexp    = regexp.MustCompile(`\s+`)
+)
+
+type SlowQuery struct {
+	Statement string
+	Count     uint64
+	P50       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+	LastSeen  time.Time
+}
+
+type slowStatement struct {
+	count    uint64
+	samples  []time.Duration
+	next     int
+	max      time.Duration
+	lastSeen time.Time
+}
+
+func (s *slowStatement) record(elapsed time.Duration, now time.Time) {
+	s.count++
+	if len(s.samples) < slowQuerySamples {
+		s.samples = append(s.samples, elapsed)
+	} else {
+		s.samples[s.next] = elapsed
+		s.next = (s.next + 1) % slowQuerySamples
+	}
+	if elapsed > s.max {
+		s.max = elapsed
+	}
+	s.lastSeen = now
+}
+
+type slowQueryTracker struct {
+	topN       int
+	mu         sync.Mutex
+	statements map[string]*slowStatement
+}
+
+func newSlowQueryTracker(topN int) *slowQueryTracker {
+	return &slowQueryTracker{topN: topN, statements: make(map[string]*slowStatement)}
+}
+
+func (t *slowQueryTracker) record(sql string, elapsed time.Duration) {
+	statement := normalizeStatement(sql)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, found := t.statements[statement]
+	if !found {
+		if len(t.statements) >= t.topN*slowQueryTrackedFactor && !t.evictFasterThan(elapsed) {
+			return
+		}
+		entry = &slowStatement{}
+		t.statements[statement] = entry
+	}
+	entry.record(elapsed, now)
+}
+
+func (t *slowQueryTracker) evictFasterThan(elapsed time.Duration) bool {
+	var fastest string
+	var fastestMax time.Duration
+	for statement, entry := range t.statements {
+		if fastest == "" || entry.max < fastestMax {
+			fastest = statement
+			fastestMax = entry.max
+		}
+	}
+	if fastest == "" || fastestMax >= elapsed {
+		return false
+	}
+	delete(t.statements, fastest)
+	return true
+}
+
+func (t *slowQueryTracker) top() []SlowQuery {
+	t.mu.Lock()
+	result := make([]SlowQuery, 0, len(t.statements))
+	for statement, entry := range t.statements {
+		samples := append([]time.Duration(nil), entry.samples...)
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		result = append(result, SlowQuery{
+			Statement: statement,
+			Count:     entry.count,
+			P50:       percentile(samples, 0.5),
+			P99:       percentile(samples, 0.99),
+			Max:       entry.max,
+			LastSeen:  entry.lastSeen,
+		})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].P99 != result[j].P99 {
+			return result[i].P99 > result[j].P99
+		}
+		return result[i].Statement < result[j].Statement
+	})
+	if len(result) > t.topN {
+		result = result[:t.topN]
+	}
+	return result
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p+0.5)]
+}
+
+func normalizeStatement(sql string) string {
+	sql = sqlStringLiteralRegexp.ReplaceAllString(sql, "?")
+	sql = sqlPlaceholderRegexp.ReplaceAllString(sql, "?")
+	sql = sqlNumberLiteralRegexp.ReplaceAllString(sql, "?")
+	sql = sqlInListRegexp.ReplaceAllString(sql, "(?)")
+	return strings.TrimSpace(sqlWhitespaceRegexp.ReplaceAllString(sql, " "))
+}
+
+type queryLogger struct {
+	glogger.Interface
+	slowThreshold time.Duration
+	slowQueries   *slowQueryTracker
+}
+
+func (l *queryLogger) LogMode(level glogger.LogLevel) glogger.Interface {
+	copied := *l
+	copied.Interface = l.Interface.LogMode(level)
+	return &copied
+}
+
+func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	l.Interface.Trace(ctx, begin, fc, err)
+	if l.slowThreshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(begin); elapsed >= l.slowThreshold {
+		sql, _ := fc()
+		l.slowQueries.record(sql, elapsed)
+	}
+}
+
+func (l *queryLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	if filter, ok := l.Interface.(gorm.ParamsFilter); ok {
+		return filter.ParamsFilter(ctx, sql, params...)
+	}
+	return sql, params
+}
+
+func parseLogLevel(value string) (glogger.LogLevel, error) {
+	level, found := logLevels[strings.ToUpper(value)]
+	if !found {
+		return 0, fmt.Errorf("unknown %s %q, expected one of SILENT, ERROR, WARN, INFO", dbLogLevelKey, value)
+	}
+	return level, nil
+}
+
+func (instance *connection) SlowQueries() []SlowQuery {
+	return instance.slowQueries.top()
+}
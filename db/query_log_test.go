@@ -0,0 +1,73 @@
+package db
+
+import (
+	gm "github.com/onsi/gomega"
+	"os"
+	"testing"
+	"time"
+)
+
+type loggedItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+func Test_NormalizeStatement(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	gm.Expect(normalizeStatement("SELECT * FROM \"t1\" WHERE id IN (1, 2,3) AND name = 'it''s'\n  LIMIT 10")).
+		Should(gm.Equal("SELECT * FROM \"t1\" WHERE id IN (?) AND name = ? LIMIT ?"))
+	gm.Expect(normalizeStatement("UPDATE items SET name=$1 WHERE id = $2")).Should(gm.Equal("UPDATE items SET name=? WHERE id = ?"))
+}
+
+func Test_SlowQueryTracker(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	tracker := newSlowQueryTracker(2)
+	for i := 1; i <= 100; i++ {
+		tracker.record("select * from a where id = 1", time.Duration(i)*time.Millisecond)
+	}
+	tracker.record("select * from b", 500*time.Millisecond)
+	tracker.record("select * from c", time.Millisecond)
+
+	top := tracker.top()
+	gm.Expect(top).Should(gm.HaveLen(2))
+	gm.Expect(top[0].Statement).Should(gm.Equal("select * from b"))
+	gm.Expect(top[1].Statement).Should(gm.Equal("select * from a where id = ?"))
+	gm.Expect(top[1].Count).Should(gm.Equal(uint64(100)))
+	gm.Expect(top[1].P50).Should(gm.Equal(51 * time.Millisecond))
+	gm.Expect(top[1].P99).Should(gm.Equal(99 * time.Millisecond))
+	gm.Expect(top[1].Max).Should(gm.Equal(100 * time.Millisecond))
+	gm.Expect(top[1].LastSeen).ShouldNot(gm.BeZero())
+}
+
+func Test_SlowQueries(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("SLOW_TEST_DB_SQLITE_PATH", "file:slow_test:?mode=memory&cache=shared")
+	_ = os.Setenv("SLOW_TEST_DB_SLOW_QUERY_THRESHOLD", "1ns")
+	_ = os.Setenv("SLOW_TEST_DB_LOG_LEVEL", "silent")
+
+	conn := NewConnection("slow_test", "slow_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&loggedItem{})
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		for i := 1; i <= 3; i++ {
+			if err := session.Create(&loggedItem{Id: int64(i), Name: "secret"}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})).Should(gm.BeNil())
+
+	found := false
+	for _, query := range conn.SlowQueries() {
+		gm.Expect(query.Statement).ShouldNot(gm.ContainSubstring("secret"))
+		if query.Statement == "INSERT INTO `logged_items` (`name`,`id`) VALUES (?) RETURNING `id`" {
+			found = true
+			gm.Expect(query.Count).Should(gm.Equal(uint64(3)))
+		}
+	}
+	gm.Expect(found).Should(gm.BeTrue())
+}
@@ -0,0 +1,16 @@
+package queue
+
+import (
+	"github.com/sedmess/go-ctx/ctx"
+	"sync"
+)
+
+var defaultServices = sync.OnceValue(func() ctx.ServicePackage {
+	return ctx.PackageOf(
+		&Service{},
+	)
+})
+
+func Default() ctx.ServicePackage {
+	return defaultServices()
+}
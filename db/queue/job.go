@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"encoding/json"
+	"github.com/sedmess/go-ctx-base/db"
+	"time"
+)
+
+const (
+	StatusPending = "PENDING"
+	StatusRunning = "RUNNING"
+	StatusDone    = "DONE"
+	StatusDead    = "DEAD"
+)
+
+type Job struct {
+	Id          int64     `gorm:"primaryKey;autoIncrement"`
+	Queue       string    `gorm:"not null;index:idx_queue_jobs_claim,priority:1"`
+	Status      string    `gorm:"not null;index:idx_queue_jobs_claim,priority:2"`
+	RunAt       time.Time `gorm:"not null;index:idx_queue_jobs_claim,priority:3"`
+	Payload     []byte    `gorm:"not null"`
+	Attempts    int       `gorm:"not null"`
+	MaxAttempts int       `gorm:"not null"`
+	LockedUntil *time.Time
+	LastError   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Job) TableName() string {
+	return "queue_jobs"
+}
+
+type TypedJob[T any] struct {
+	*Job
+	Data T
+}
+
+type EnqueueOptions struct {
+	Delay       time.Duration
+	MaxAttempts int
+}
+
+func Enqueue[T any](session *db.Session, queue string, payload T, opts ...EnqueueOptions) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	var options EnqueueOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	job := &Job{
+		Queue:       queue,
+		Status:      StatusPending,
+		RunAt:       time.Now().Add(options.Delay),
+		Payload:     data,
+		MaxAttempts: options.MaxAttempts,
+	}
+	if err := session.Create(job).Error; err != nil {
+		return 0, err
+	}
+	return job.Id, nil
+}
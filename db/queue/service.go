@@ -0,0 +1,317 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx-base/db"
+	"github.com/sedmess/go-ctx-base/utils/concurrent"
+	"github.com/sedmess/go-ctx/ctx/health"
+	"github.com/sedmess/go-ctx/logger"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minVisibilityTimeout keeps the heartbeat period (a third of the visibility timeout) above zero
+const minVisibilityTimeout = 3 * time.Millisecond
+
+var errUnknownQueue = errors.New("no handler registered for queue")
+
+type Handler[T any] func(ctx context.Context, job *TypedJob[T]) error
+
+type Service struct {
+	l  logger.Logger `logger:""`
+	db db.Connection `inject:""`
+
+	workers           int           `env:"QUEUE_WORKERS" envDef:"4"`
+	pollInterval      time.Duration `env:"QUEUE_POLL_INTERVAL" envDef:"1s"`
+	visibilityTimeout time.Duration `env:"QUEUE_VISIBILITY_TIMEOUT" envDef:"30s"`
+	maxAttempts       int           `env:"QUEUE_MAX_ATTEMPTS" envDef:"5"`
+	initialBackoff    time.Duration `env:"QUEUE_INITIAL_BACKOFF" envDef:"1s"`
+	maxBackoff        time.Duration `env:"QUEUE_MAX_BACKOFF" envDef:"10m"`
+
+	policy   db.RetryPolicy
+	mu       sync.RWMutex
+	handlers map[string]func(ctx context.Context, job *Job) error
+
+	pool     *concurrent.ExecutionPool
+	busy     atomic.Int32
+	cancelFn context.CancelFunc
+	doneCh   chan bool
+	wakeCh   chan bool
+}
+
+func Register[T any](service *Service, queue string, handler Handler[T]) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.handlers[queue] = func(ctx context.Context, job *Job) error {
+		typedJob := &TypedJob[T]{Job: job}
+		if err := json.Unmarshal(job.Payload, &typedJob.Data); err != nil {
+			return fmt.Errorf("can't decode payload: %w", err)
+		}
+		return handler(ctx, typedJob)
+	}
+}
+
+func (instance *Service) Init() {
+	if err := instance.validate(); err != nil {
+		instance.l.Fatal(err)
+	}
+	instance.handlers = make(map[string]func(ctx context.Context, job *Job) error)
+	instance.policy = db.RetryPolicy{
+		MaxAttempts:    instance.maxAttempts,
+		InitialBackoff: instance.initialBackoff,
+		MaxBackoff:     instance.maxBackoff,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+	instance.wakeCh = make(chan bool, 1)
+	instance.db.AutoMigrate(&Job{})
+}
+
+func (instance *Service) validate() error {
+	if instance.workers <= 0 {
+		return fmt.Errorf("QUEUE_WORKERS must be positive, got %d", instance.workers)
+	}
+	if instance.pollInterval <= 0 {
+		return fmt.Errorf("QUEUE_POLL_INTERVAL must be positive, got %s", instance.pollInterval)
+	}
+	if instance.visibilityTimeout < minVisibilityTimeout {
+		return fmt.Errorf("QUEUE_VISIBILITY_TIMEOUT must be at least %s, got %s", minVisibilityTimeout, instance.visibilityTimeout)
+	}
+	return nil
+}
+
+func (instance *Service) AfterStart() {
+	var pollContext context.Context
+	pollContext, instance.cancelFn = context.WithCancel(context.Background())
+	instance.pool = concurrent.NewPool(instance.workers)
+	instance.doneCh = make(chan bool)
+	go instance.run(pollContext)
+}
+
+func (instance *Service) BeforeStop() {
+	instance.cancelFn()
+	<-instance.doneCh
+	instance.pool.AwaitAll()
+}
+
+func (instance *Service) Wake() {
+	select {
+	case instance.wakeCh <- true:
+	default:
+	}
+}
+
+func (instance *Service) Health() health.ServiceHealth {
+	status := health.ServiceHealth{Status: health.Up, Details: make(map[string]any)}
+	status.Details["busyWorkers"] = instance.busy.Load()
+
+	err := instance.db.Session(func(session *db.Session) error {
+		type statusCount struct {
+			Status string
+			Count  int64
+		}
+		var counts []statusCount
+		if err := session.Model(&Job{}).Select("status, count(*) as count").Where("status <> ?", StatusDone).Group("status").Scan(&counts).Error; err != nil {
+			return err
+		}
+		for _, count := range counts {
+			status.Details[count.Status] = count.Count
+			if count.Status == StatusDead && count.Count > 0 {
+				status.Status = health.Partially
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		status.Status = health.Down
+		status.Details["error"] = err.Error()
+	}
+	return status
+}
+
+func (instance *Service) queues() []string {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+
+	queues := make([]string, 0, len(instance.handlers))
+	for queue := range instance.handlers {
+		queues = append(queues, queue)
+	}
+	return queues
+}
+
+func (instance *Service) run(ctx context.Context) {
+	defer close(instance.doneCh)
+
+	ticker := time.NewTicker(instance.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			free := instance.workers - int(instance.busy.Load())
+			if free <= 0 {
+				break
+			}
+			jobs, err := instance.claim(ctx, free)
+			if err != nil {
+				if ctx.Err() == nil {
+					instance.l.Error("on claiming jobs:", err)
+				}
+				break
+			}
+			for _, job := range jobs {
+				instance.busy.Add(1)
+				job := job
+				instance.pool.Execute(func() {
+					defer instance.busy.Add(-1)
+					instance.process(ctx, job)
+				})
+			}
+			if len(jobs) < free {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-instance.wakeCh:
+		}
+	}
+}
+
+func (instance *Service) claim(ctx context.Context, limit int) ([]*Job, error) {
+	queues := instance.queues()
+	if len(queues) == 0 {
+		return nil, nil
+	}
+	return db.SessionContextTxReturning(ctx, instance.db, func(session *db.Session) ([]*Job, error) {
+		now := time.Now()
+		var ids []int64
+		err := session.LockForUpdateSkipLocked().Model(&Job{}).
+			Where("queue IN ? AND ((status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?))", queues, StatusPending, now, StatusRunning, now).
+			Order("run_at, id").Limit(limit).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return nil, err
+		}
+		err = session.Model(&Job{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":       StatusRunning,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"locked_until": now.Add(instance.visibilityTimeout),
+			"updated_at":   now,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+		var jobs []*Job
+		err = session.Where("id IN ?", ids).Order("run_at, id").Find(&jobs).Error
+		return jobs, err
+	})
+}
+
+func (instance *Service) process(ctx context.Context, job *Job) {
+	maxAttempts := job.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = instance.maxAttempts
+	}
+	if job.Attempts > maxAttempts {
+		instance.complete(job, errors.New("visibility timeout expired on the last attempt"), maxAttempts)
+		return
+	}
+
+	instance.mu.RLock()
+	handler, found := instance.handlers[job.Queue]
+	instance.mu.RUnlock()
+
+	jobContext, cancelFn := context.WithCancel(ctx)
+	heartbeatDone := make(chan bool)
+	go instance.heartbeat(jobContext, job, heartbeatDone)
+
+	var err error
+	if found {
+		err = instance.invoke(jobContext, handler, job)
+	} else {
+		err = fmt.Errorf("%w %s", errUnknownQueue, job.Queue)
+	}
+	cancelFn()
+	<-heartbeatDone
+
+	if err != nil && ctx.Err() != nil {
+		instance.release(job)
+		return
+	}
+	instance.complete(job, err, maxAttempts)
+}
+
+func (instance *Service) invoke(ctx context.Context, handler func(ctx context.Context, job *Job) error, job *Job) (err error) {
+	defer func() {
+		if reason := recover(); reason != nil {
+			err = fmt.Errorf("handler panicked: %v", reason)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (instance *Service) heartbeat(ctx context.Context, job *Job, done chan bool) {
+	defer close(done)
+
+	ticker := time.NewTicker(instance.visibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := instance.db.Session(func(session *db.Session) error {
+				return instance.owned(session, job).Update("locked_until", time.Now().Add(instance.visibilityTimeout)).Error
+			})
+			instance.l.LogIfError("heartbeat", err)
+		}
+	}
+}
+
+func (instance *Service) complete(job *Job, jobErr error, maxAttempts int) {
+	updates := map[string]any{"locked_until": nil, "updated_at": time.Now()}
+	if jobErr == nil {
+		updates["status"] = StatusDone
+		updates["last_error"] = ""
+	} else if job.Attempts >= maxAttempts {
+		instance.l.Error("job", job.Id, "of queue", job.Queue, "dead-lettered after", job.Attempts, "attempts:", jobErr)
+		updates["status"] = StatusDead
+		updates["last_error"] = jobErr.Error()
+	} else {
+		backoff := instance.policy.Backoff(job.Attempts)
+		instance.l.Debug("job", job.Id, "of queue", job.Queue, "will be retried in", backoff, ":", jobErr)
+		updates["status"] = StatusPending
+		updates["run_at"] = time.Now().Add(backoff)
+		updates["last_error"] = jobErr.Error()
+	}
+	err := instance.db.Session(func(session *db.Session) error {
+		return instance.owned(session, job).Updates(updates).Error
+	})
+	instance.l.LogIfError("completing job", err)
+}
+
+func (instance *Service) release(job *Job) {
+	err := instance.db.Session(func(session *db.Session) error {
+		return instance.owned(session, job).Updates(map[string]any{
+			"status":       StatusPending,
+			"attempts":     job.Attempts - 1,
+			"locked_until": nil,
+			"updated_at":   time.Now(),
+		}).Error
+	})
+	instance.l.LogIfError("releasing job", err)
+}
+
+func (instance *Service) owned(session *db.Session, job *Job) *gorm.DB {
+	return session.Model(&Job{}).Where("id = ? and status = ? and attempts = ?", job.Id, StatusRunning, job.Attempts)
+}
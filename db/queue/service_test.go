@@ -0,0 +1,90 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"github.com/sedmess/go-ctx-base/db"
+	"github.com/sedmess/go-ctx/ctx"
+	"github.com/sedmess/go-ctx/ctx/ctx_testing"
+	"github.com/sedmess/go-ctx/ctx/health"
+	"github.com/sedmess/go-ctx/u"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	Value int
+}
+
+func Test_Service(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	service := &Service{}
+	ctx_testing.CreateTestingApplication(
+		ctx.PackageOf(
+			ctx.WithName(u.GetInterfaceName[db.Connection](), db.NewConnection("queue_test", "queue_test", false, false)),
+			service,
+		),
+	).
+		WithParameter("QUEUE_TEST_DB_SQLITE_PATH", "file:queue_test:?mode=memory&cache=shared").
+		WithParameter("QUEUE_POLL_INTERVAL", "20ms").
+		WithParameter("QUEUE_MAX_ATTEMPTS", "2").
+		WithParameter("QUEUE_INITIAL_BACKOFF", "0s").
+		Run(func() int {
+			testService(service)
+			return 0
+		})
+}
+
+func testService(service *Service) {
+	sum := atomic.Int64{}
+	Register(service, "sum", func(ctx context.Context, job *TypedJob[testPayload]) error {
+		sum.Add(int64(job.Data.Value))
+		return nil
+	})
+	failures := atomic.Int64{}
+	Register(service, "failing", func(ctx context.Context, job *TypedJob[testPayload]) error {
+		failures.Add(1)
+		return errors.New("failure")
+	})
+
+	gm.Expect(service.db.Session(func(session *db.Session) error {
+		return session.Tx(func(session *db.Session) error {
+			for i := 1; i <= 10; i++ {
+				if _, err := Enqueue(session, "sum", testPayload{Value: i}); err != nil {
+					return err
+				}
+			}
+			_, err := Enqueue(session, "failing", testPayload{})
+			return err
+		})
+	})).Should(gm.BeNil())
+	service.Wake()
+
+	gm.Eventually(sum.Load).WithTimeout(5 * time.Second).Should(gm.Equal(int64(55)))
+	gm.Eventually(failures.Load).WithTimeout(5 * time.Second).Should(gm.Equal(int64(2)))
+	gm.Eventually(func() health.ServiceHealthStatus {
+		return service.Health().Status
+	}).WithTimeout(5 * time.Second).Should(gm.Equal(health.Partially))
+
+	var dead Job
+	gm.Expect(service.db.Session(func(session *db.Session) error {
+		return session.Where("queue = ?", "failing").First(&dead).Error
+	})).Should(gm.BeNil())
+	gm.Expect(dead.Status).Should(gm.Equal(StatusDead))
+	gm.Expect(dead.Attempts).Should(gm.Equal(2))
+}
+
+func Test_ServiceSettings(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	gm.Expect((&Service{workers: 1, pollInterval: time.Second, visibilityTimeout: 30 * time.Second}).validate()).Should(gm.BeNil())
+	gm.Expect((&Service{workers: 0, pollInterval: time.Second, visibilityTimeout: 30 * time.Second}).validate()).
+		Should(gm.MatchError("QUEUE_WORKERS must be positive, got 0"))
+	gm.Expect((&Service{workers: 1, pollInterval: 0, visibilityTimeout: 30 * time.Second}).validate()).
+		Should(gm.MatchError("QUEUE_POLL_INTERVAL must be positive, got 0s"))
+	gm.Expect((&Service{workers: 1, pollInterval: time.Second, visibilityTimeout: 2 * time.Nanosecond}).validate()).
+		Should(gm.MatchError("QUEUE_VISIBILITY_TIMEOUT must be at least 3ms, got 2ns"))
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sedmess/go-ctx/ctx/health"
+	"github.com/sedmess/go-ctx/logger"
+	"gorm.io/gorm"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	balancerRoundRobin       = "ROUND_ROBIN"
+	balancerLeastConnections = "LEAST_CONNECTIONS"
+)
+
+const defaultReplicaEjectTimeout = 30 * time.Second
+
+type replica struct {
+	name         string
+	dsn          string
+	db           *gorm.DB
+	ejectedUntil atomic.Int64
+}
+
+func (r *replica) available(now time.Time) bool {
+	return r.ejectedUntil.Load() <= now.UnixNano()
+}
+
+func (r *replica) inUse() int {
+	sqlDb, err := r.db.DB()
+	if err != nil {
+		return 0
+	}
+	return sqlDb.Stats().InUse
+}
+
+func (r *replica) check() error {
+	timeoutContext, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+	return checkConnection(timeoutContext, r.db)
+}
+
+type replicaPool struct {
+	logger       logger.Logger
+	replicas     []*replica
+	balancer     string
+	ejectTimeout time.Duration
+	next         atomic.Uint64
+}
+
+func newReplicaPool(logger logger.Logger, replicas []*replica, balancer string, ejectTimeout time.Duration) *replicaPool {
+	if balancer != balancerRoundRobin && balancer != balancerLeastConnections {
+		logger.Fatal("unknown", dbReplicaBalancerKey, ":", balancer)
+	}
+	return &replicaPool{logger: logger, replicas: replicas, balancer: balancer, ejectTimeout: ejectTimeout}
+}
+
+func (p *replicaPool) pick() *replica {
+	if len(p.replicas) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if p.balancer == balancerLeastConnections {
+		var best *replica
+		bestInUse := 0
+		for _, r := range p.replicas {
+			if !r.available(now) {
+				continue
+			}
+			if inUse := r.inUse(); best == nil || inUse < bestInUse {
+				best = r
+				bestInUse = inUse
+			}
+		}
+		return best
+	}
+	start := p.next.Add(1)
+	for i := 0; i < len(p.replicas); i++ {
+		r := p.replicas[(start+uint64(i))%uint64(len(p.replicas))]
+		if r.available(now) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (p *replicaPool) eject(r *replica, err error) {
+	r.ejectedUntil.Store(time.Now().Add(p.ejectTimeout).UnixNano())
+	p.logger.Error("replica", r.name, "ejected for", p.ejectTimeout, ":", err)
+}
+
+func (p *replicaPool) health() (map[string]health.ServiceHealth, bool) {
+	result := make(map[string]health.ServiceHealth, len(p.replicas))
+	allUp := true
+	for _, r := range p.replicas {
+		status := health.ServiceHealth{Details: map[string]any{"dsn": r.dsn}}
+		if err := r.check(); err != nil {
+			if r.available(time.Now()) {
+				p.eject(r, err)
+			}
+			status.Status = health.Down
+			status.Details["error"] = err.Error()
+			allUp = false
+		} else {
+			if sqlDb, err := r.db.DB(); err == nil {
+				status.Details["stats"] = sqlDb.Stats()
+			}
+			if r.available(time.Now()) {
+				status.Status = health.Up
+			} else {
+				status.Status = health.Partially
+				status.Details["ejectedUntil"] = time.Unix(0, r.ejectedUntil.Load())
+			}
+		}
+		result[r.name] = status
+	}
+	return result, allUp
+}
+
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr)
+}
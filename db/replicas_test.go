@@ -0,0 +1,51 @@
+package db
+
+import (
+	gm "github.com/onsi/gomega"
+	"os"
+	"testing"
+)
+
+type replicatedItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+func Test_ReadSession(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("REPLICA_TEST_DB_SQLITE_PATH", "file:replica_test_primary:?mode=memory&cache=shared")
+	_ = os.Setenv("REPLICA_TEST_DB_REPLICA_DSNS", "file:replica_test_r0:?mode=memory&cache=shared,file:replica_test_r1:?mode=memory&cache=shared")
+
+	conn := NewConnection("replica_test", "replica_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&replicatedItem{})
+
+	for _, r := range conn.(*connection).replicas.replicas {
+		gm.Expect(r.db.AutoMigrate(&replicatedItem{})).Should(gm.BeNil())
+		gm.Expect(r.db.Create(&replicatedItem{Id: 1, Name: r.name}).Error).Should(gm.BeNil())
+	}
+
+	names := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		gm.Expect(conn.ReadSession(func(session *Session) error {
+			var item replicatedItem
+			if err := session.First(&item).Error; err != nil {
+				return err
+			}
+			names[item.Name] = true
+			return session.Tx(func(session *Session) error {
+				return session.Create(&replicatedItem{Id: int64(10 + i), Name: "primary"}).Error
+			})
+		})).Should(gm.BeNil())
+	}
+	gm.Expect(names).Should(gm.HaveLen(2))
+
+	var count int64
+	gm.Expect(conn.Session(func(session *Session) error {
+		return session.Model(&replicatedItem{}).Count(&count).Error
+	})).Should(gm.BeNil())
+	gm.Expect(count).Should(gm.Equal(int64(4)))
+
+	gm.Expect(conn.Health().Components).Should(gm.HaveLen(2))
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"github.com/sedmess/go-ctx-base/utils/channels"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Specification func(db *gorm.DB) *gorm.DB
+
+func Where(query any, args ...any) Specification {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(query, args...)
+	}
+}
+
+func OrderBy(columns ...KeysetColumn) Specification {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, column := range columns {
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: column.Name}, Desc: column.Desc})
+		}
+		return db
+	}
+}
+
+type Repository[T any, ID comparable] struct {
+	connection Connection
+}
+
+func NewRepository[T any, ID comparable](connection Connection) *Repository[T, ID] {
+	return &Repository[T, ID]{connection: connection}
+}
+
+func (r *Repository[T, ID]) FindByID(session *Session, id ID) (T, error) {
+	var entity T
+	err := r.read(session, func(session *Session) error {
+		return session.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Take(&entity).Error
+	})
+	return entity, err
+}
+
+func (r *Repository[T, ID]) FindAll(session *Session, specs ...Specification) ([]T, error) {
+	var entities []T
+	err := r.read(session, func(session *Session) error {
+		return session.Scopes(scopes(specs)...).Find(&entities).Error
+	})
+	return entities, err
+}
+
+func (r *Repository[T, ID]) FindPage(session *Session, paginator Paginator, specs ...Specification) ([]T, error) {
+	var entities []T
+	err := r.read(session, func(session *Session) error {
+		result := session.Scopes(scopes(specs)...).Scopes(paginator.Scope()).Find(&entities)
+		if result.Error != nil {
+			return result.Error
+		}
+		paginator.OffsetResult(result)
+		return nil
+	})
+	return entities, err
+}
+
+func (r *Repository[T, ID]) Count(session *Session, specs ...Specification) (int64, error) {
+	var count int64
+	err := r.read(session, func(session *Session) error {
+		return session.Model(new(T)).Scopes(scopes(specs)...).Count(&count).Error
+	})
+	return count, err
+}
+
+func (r *Repository[T, ID]) Exists(session *Session, specs ...Specification) (bool, error) {
+	var found []int
+	err := r.read(session, func(session *Session) error {
+		return session.Model(new(T)).Scopes(scopes(specs)...).Select("1").Limit(1).Find(&found).Error
+	})
+	return len(found) > 0, err
+}
+
+func (r *Repository[T, ID]) Save(session *Session, entity *T) error {
+	return r.write(session, func(session *Session) error {
+		return session.Save(entity).Error
+	})
+}
+
+func (r *Repository[T, ID]) Delete(session *Session, entity *T) error {
+	return r.write(session, func(session *Session) error {
+		return session.Delete(entity).Error
+	})
+}
+
+func (r *Repository[T, ID]) DeleteByID(session *Session, id ID) (bool, error) {
+	var deleted int64
+	err := r.write(session, func(session *Session) error {
+		result := session.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(new(T))
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted > 0, err
+}
+
+func (r *Repository[T, ID]) DeleteAll(session *Session, specs ...Specification) (int64, error) {
+	var deleted int64
+	err := r.write(session, func(session *Session) error {
+		result := session.Scopes(scopes(specs)...).Delete(new(T))
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
+}
+
+func (r *Repository[T, ID]) Stream(ctx context.Context, paginator Paginator, specs ...Specification) channels.StreamingChan[T] {
+	return sessionContextPaginatedStream[T](ctx, r.connection, paginator, func(session *gorm.DB) *gorm.DB {
+		return session.Scopes(scopes(specs)...)
+	})
+}
+
+func (r *Repository[T, ID]) read(session *Session, fn func(session *Session) error) error {
+	if session != nil {
+		return fn(session)
+	}
+	return r.connection.Session(fn)
+}
+
+func (r *Repository[T, ID]) write(session *Session, fn func(session *Session) error) error {
+	if session != nil {
+		return session.Tx(fn)
+	}
+	return r.connection.Session(func(session *Session) error {
+		return session.Tx(fn)
+	})
+}
+
+func scopes(specs []Specification) []func(db *gorm.DB) *gorm.DB {
+	result := make([]func(db *gorm.DB) *gorm.DB, len(specs))
+	for i, spec := range specs {
+		result[i] = spec
+	}
+	return result
+}
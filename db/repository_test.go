@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"os"
+	"testing"
+)
+
+type repositoryItem struct {
+	Id    int64 `gorm:"primaryKey"`
+	Owner string
+	Value int
+}
+
+type repositoryTag struct {
+	Code  string `gorm:"primaryKey"`
+	Title string
+}
+
+func Test_Repository(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("REPOSITORY_TEST_DB_SQLITE_PATH", "file:repository_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("repository_test", "repository_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&repositoryItem{}, &repositoryTag{})
+
+	items := NewRepository[repositoryItem, int64](conn)
+	for i := 1; i <= 5; i++ {
+		owner := "alice"
+		if i%2 == 0 {
+			owner = "bob"
+		}
+		gm.Expect(items.Save(nil, &repositoryItem{Id: int64(i), Owner: owner, Value: i})).Should(gm.BeNil())
+	}
+
+	item, err := items.FindByID(nil, 3)
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(item.Value).Should(gm.Equal(3))
+
+	_, err = items.FindByID(nil, 42)
+	gm.Expect(IsErrNotFound(err)).Should(gm.BeTrue())
+
+	alice, err := items.FindAll(nil, Where("owner = ?", "alice"), OrderBy(Desc("id")))
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(alice).Should(gm.HaveLen(3))
+	gm.Expect(alice[0].Id).Should(gm.Equal(int64(5)))
+
+	count, err := items.Count(nil, Where("owner = ?", "bob"))
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(count).Should(gm.Equal(int64(2)))
+
+	exists, err := items.Exists(nil, Where("value > ?", 4))
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(exists).Should(gm.BeTrue())
+	exists, err = items.Exists(nil, Where("value > ?", 5))
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(exists).Should(gm.BeFalse())
+
+	paginator := NewKeysetPaginator(2, Asc("id"))
+	var paged []int64
+	for paginator.HasNext() {
+		page, err := items.FindPage(nil, paginator)
+		gm.Expect(err).Should(gm.BeNil())
+		for _, item := range page {
+			paged = append(paged, item.Id)
+		}
+	}
+	gm.Expect(paged).Should(gm.Equal([]int64{1, 2, 3, 4, 5}))
+
+	streamed, err := items.Stream(context.Background(), NewPaginator(2), Where("owner = ?", "alice"), OrderBy(Asc("id"))).CollectToSlice()
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(streamed).Should(gm.HaveLen(3))
+
+	rollback := errors.New("rollback")
+	err = conn.Session(func(session *Session) error {
+		return session.Tx(func(session *Session) error {
+			if err := items.Save(session, &repositoryItem{Id: 6, Owner: "carol"}); err != nil {
+				return err
+			}
+			count, err := items.Count(session, Where("owner = ?", "carol"))
+			gm.Expect(err).Should(gm.BeNil())
+			gm.Expect(count).Should(gm.Equal(int64(1)))
+			return rollback
+		})
+	})
+	gm.Expect(err).Should(gm.Equal(rollback))
+	exists, err = items.Exists(nil, Where("owner = ?", "carol"))
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(exists).Should(gm.BeFalse())
+
+	deleted, err := items.DeleteByID(nil, 1)
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(deleted).Should(gm.BeTrue())
+	gm.Expect(items.Delete(nil, &item)).Should(gm.BeNil())
+	removed, err := items.DeleteAll(nil, Where("owner = ?", "bob"))
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(removed).Should(gm.Equal(int64(2)))
+	count, err = items.Count(nil)
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(count).Should(gm.Equal(int64(1)))
+
+	tags := NewRepository[repositoryTag, string](conn)
+	gm.Expect(tags.Save(nil, &repositoryTag{Code: "go", Title: "Golang"})).Should(gm.BeNil())
+	tag, err := tags.FindByID(nil, "go")
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(tag.Title).Should(gm.Equal("Golang"))
+}
@@ -2,6 +2,9 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/sedmess/go-ctx/logger"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -9,25 +12,183 @@ import (
 type Session struct {
 	context.Context
 	*gorm.DB
-	inTx bool
+	inTx      bool
+	txDepth   int
+	txOptions *TxOptions
+	txHooks   *txHooks
+	primary   *gorm.DB
+	tenant    string
+	// readOnlyLocal keeps read-only transactions on the session pool instead of the primary
+	readOnlyLocal bool
+}
+
+type txHooks struct {
+	afterCommit   []func()
+	afterRollback []func(err error)
+}
+
+func (h *txHooks) merge(child *txHooks) {
+	h.afterCommit = append(h.afterCommit, child.afterCommit...)
+	h.afterRollback = append(h.afterRollback, child.afterRollback...)
+}
+
+func (h *txHooks) runAfterCommit() {
+	for _, hook := range h.afterCommit {
+		runHook(func() { hook() })
+	}
+}
+
+func (h *txHooks) runAfterRollback(err error) {
+	for _, hook := range h.afterRollback {
+		runHook(func() { hook(err) })
+	}
+}
+
+func runHook(hook func()) {
+	defer func() {
+		if reason := recover(); reason != nil {
+			logger.Error("DB", "transaction hook panicked:", reason)
+		}
+	}()
+	hook()
 }
 
 func newSession(parentContext context.Context, db *gorm.DB) *Session {
 	return &Session{Context: parentContext, DB: db.WithContext(parentContext), inTx: false}
 }
 
-func (s *Session) Tx(txFunc func(session *Session) error) error {
+func (s *Session) Tx(txFunc func(session *Session) error, opts ...*TxOptions) error {
+	options := firstTxOptions(opts)
 	var err error
 	if s.inTx {
-		err = txFunc(&Session{Context: s.Context, DB: s.DB, inTx: true})
+		if err = options.satisfiedBy(s.txOptions); err != nil {
+			return err
+		}
+		if options != nil && options.Savepoint {
+			err = s.savepointTx(txFunc)
+		} else {
+			err = txFunc(s.nested(s.DB, s.txDepth, s.txHooks))
+		}
 	} else {
-		err = s.DB.Transaction(func(tx *gorm.DB) error {
-			return txFunc(&Session{Context: s.Context, DB: tx, inTx: true})
-		})
+		txDB := s.DB
+		if s.primary != nil && !(s.readOnlyLocal && options != nil && options.ReadOnly) {
+			txDB = s.primary
+		}
+		txContext, cancelFn := s.txContext()
+		defer cancelFn()
+		txDB = txDB.WithContext(txContext)
+		hooks := &txHooks{}
+		committed := false
+		defer func() {
+			if committed {
+				hooks.runAfterCommit()
+			} else if reason := recover(); reason != nil {
+				hooks.runAfterRollback(fmt.Errorf("transaction panicked: %v", reason))
+				panic(reason)
+			} else {
+				hooks.runAfterRollback(err)
+			}
+		}()
+		err = txDB.Transaction(func(tx *gorm.DB) error {
+			if options != nil && options.Deferrable {
+				if tx.Dialector.Name() != DriverPostgres {
+					return fmt.Errorf("%w: deferrable", ErrTxOptionUnsupported)
+				}
+				if err := tx.Exec("SET TRANSACTION DEFERRABLE").Error; err != nil {
+					return err
+				}
+			}
+			if err := s.setLocalTenant(tx); err != nil {
+				return err
+			}
+			return txFunc(&Session{Context: txContext, DB: tx, inTx: true, txOptions: options, txHooks: hooks, tenant: s.tenant})
+		}, options.sqlTxOptions())
+		committed = err == nil
 	}
 	return err
 }
 
+func (s *Session) nested(db *gorm.DB, depth int, hooks *txHooks) *Session {
+	return &Session{Context: s.Context, DB: db, inTx: true, txDepth: depth, txOptions: s.txOptions, txHooks: hooks, tenant: s.tenant}
+}
+
+// WithBaseContext returns a copy of the session, in the same transaction if any, whose statements run with baseContext
+func (s *Session) WithBaseContext(baseContext context.Context) *Session {
+	session := *s
+	session.Context = baseContext
+	session.DB = s.DB.WithContext(baseContext)
+	if s.primary != nil {
+		session.primary = s.primary.WithContext(baseContext)
+	}
+	return &session
+}
+
+func (s *Session) InTx() bool {
+	return s.inTx
+}
+
+func (s *Session) AfterCommit(hook func()) {
+	if s.inTx {
+		s.txHooks.afterCommit = append(s.txHooks.afterCommit, hook)
+	} else {
+		runHook(hook)
+	}
+}
+
+func (s *Session) AfterRollback(hook func(err error)) {
+	if s.inTx {
+		s.txHooks.afterRollback = append(s.txHooks.afterRollback, hook)
+	} else {
+		runHook(func() { hook(nil) })
+	}
+}
+
+func (s *Session) savepointTx(txFunc func(session *Session) error) (err error) {
+	name := fmt.Sprintf("ctx_base_sp_%d", s.txDepth+1)
+	if err = s.DB.SavePoint(name).Error; err != nil {
+		return err
+	}
+
+	hooks := &txHooks{}
+	completed := false
+	defer func() {
+		if !completed {
+			s.DB.RollbackTo(name)
+		}
+	}()
+
+	err = txFunc(s.nested(s.DB, s.txDepth+1, hooks))
+	completed = true
+	if err == nil {
+		// SQL Server has no RELEASE, its savepoints live until the transaction ends
+		if s.Dialector.Name() != DriverSQLServer {
+			err = s.DB.Exec("RELEASE SAVEPOINT " + name).Error
+		}
+	} else if rollbackErr := s.DB.RollbackTo(name).Error; rollbackErr != nil {
+		err = errors.Join(err, rollbackErr)
+	}
+	if err != nil {
+		hooks.runAfterRollback(err)
+		return err
+	}
+	s.txHooks.merge(hooks)
+	return nil
+}
+
 func (s *Session) LockForUpdate() *gorm.DB {
+	if s.Dialector.Name() == DriverSQLServer {
+		return s.Clauses(tableHint(sqlServerUpdateLockHint))
+	}
 	return s.Clauses(clause.Locking{Strength: "UPDATE"})
 }
+
+func (s *Session) LockForUpdateSkipLocked() *gorm.DB {
+	switch s.Dialector.Name() {
+	case DriverSQLServer:
+		return s.Clauses(tableHint(sqlServerSkipLockedHint))
+	case DriverSQLite:
+		return s.DB
+	default:
+		return s.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	}
+}
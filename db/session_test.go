@@ -0,0 +1,124 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"os"
+	"testing"
+)
+
+func Test_TxOptionsConflict(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("TX_OPTIONS_TEST_DB_SQLITE_PATH", "file:tx_options_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("tx_options_test", "tx_options_test", false, false)
+	conn.Init()
+
+	err := conn.Session(func(session *Session) error {
+		return session.Tx(func(session *Session) error {
+			return session.Tx(func(session *Session) error {
+				return nil
+			}, &TxOptions{Isolation: sql.LevelSerializable})
+		})
+	})
+	gm.Expect(errors.Is(err, ErrTxOptionsConflict)).Should(gm.BeTrue())
+
+	err = conn.Session(func(session *Session) error {
+		return session.Tx(func(session *Session) error {
+			return session.Tx(func(session *Session) error {
+				return nil
+			}, &TxOptions{})
+		})
+	})
+	gm.Expect(err).Should(gm.BeNil())
+
+	_, err = SessionTxReturning(conn, func(session *Session) (int, error) {
+		return 0, nil
+	}, &TxOptions{Deferrable: true})
+	gm.Expect(errors.Is(err, ErrTxOptionUnsupported)).Should(gm.BeTrue())
+}
+
+type savepointItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+func Test_SavepointTx(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("SAVEPOINT_TEST_DB_SQLITE_PATH", "file:savepoint_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("savepoint_test", "savepoint_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&savepointItem{})
+
+	innerErr := errors.New("inner failure")
+	err := conn.Session(func(session *Session) error {
+		return session.Tx(func(session *Session) error {
+			if err := session.Create(&savepointItem{Id: 1, Name: "outer"}).Error; err != nil {
+				return err
+			}
+			err := session.Tx(func(session *Session) error {
+				if err := session.Create(&savepointItem{Id: 2, Name: "inner"}).Error; err != nil {
+					return err
+				}
+				return innerErr
+			}, &TxOptions{Savepoint: true})
+			gm.Expect(err).Should(gm.Equal(innerErr))
+			return session.Tx(func(session *Session) error {
+				return session.Create(&savepointItem{Id: 3, Name: "released"}).Error
+			}, &TxOptions{Savepoint: true})
+		})
+	})
+	gm.Expect(err).Should(gm.BeNil())
+
+	var items []savepointItem
+	gm.Expect(conn.Session(func(session *Session) error {
+		return session.Order("id").Find(&items).Error
+	})).Should(gm.BeNil())
+	gm.Expect(items).Should(gm.HaveLen(2))
+	gm.Expect(items[0].Id).Should(gm.Equal(int64(1)))
+	gm.Expect(items[1].Id).Should(gm.Equal(int64(3)))
+}
+
+func Test_TxHooks(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("TX_HOOKS_TEST_DB_SQLITE_PATH", "file:tx_hooks_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("tx_hooks_test", "tx_hooks_test", false, false)
+	conn.Init()
+
+	events := make([]string, 0)
+	txErr := errors.New("tx failure")
+	err := conn.Session(func(session *Session) error {
+		session.AfterCommit(func() { events = append(events, "immediate") })
+		gm.Expect(events).Should(gm.Equal([]string{"immediate"}))
+
+		gm.Expect(session.Tx(func(session *Session) error {
+			session.AfterCommit(func() { events = append(events, "outer") })
+			return session.Tx(func(session *Session) error {
+				session.AfterCommit(func() { events = append(events, "inner") })
+				session.AfterRollback(func(err error) { events = append(events, "inner rollback") })
+				gm.Expect(events).Should(gm.HaveLen(1))
+				return nil
+			})
+		})).Should(gm.BeNil())
+		gm.Expect(events).Should(gm.Equal([]string{"immediate", "outer", "inner"}))
+
+		events = events[:0]
+		gm.Expect(session.Tx(func(session *Session) error {
+			session.AfterCommit(func() { events = append(events, "commit") })
+			session.AfterRollback(func(err error) {
+				gm.Expect(err).Should(gm.Equal(txErr))
+				events = append(events, "rollback")
+			})
+			return txErr
+		})).Should(gm.Equal(txErr))
+		gm.Expect(events).Should(gm.Equal([]string{"rollback"}))
+		return nil
+	})
+	gm.Expect(err).Should(gm.BeNil())
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dbSqliteJournalModeKey = "DB_SQLITE_JOURNAL_MODE"
+const dbSqliteBusyTimeoutKey = "DB_SQLITE_BUSY_TIMEOUT"
+const dbSqliteForeignKeysKey = "DB_SQLITE_FOREIGN_KEYS"
+const dbSqliteSynchronousKey = "DB_SQLITE_SYNCHRONOUS"
+const dbSqliteTxLockKey = "DB_SQLITE_TX_LOCK"
+const dbSqliteSingleWriterKey = "DB_SQLITE_SINGLE_WRITER"
+
+const sqliteWriterPluginName = "ctx_base:sqlite_writer"
+const sqliteReaderPoolKey = "ctx_base:sqlite_reader_pool"
+
+const defaultSqliteJournalMode = "WAL"
+const defaultSqliteBusyTimeout = 5 * time.Second
+const defaultSqliteSynchronous = "NORMAL"
+const defaultSqliteTxLock = "IMMEDIATE"
+
+var sqliteJournalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+var sqliteSynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+var sqliteTxLocks = []string{"DEFERRED", "IMMEDIATE", "EXCLUSIVE"}
+
+func (instance *connection) configureSQLite(config *connectionConfig) error {
+	journalMode, err := sqliteOption(instance.getEnvFn(dbSqliteJournalModeKey).AsStringDefault(defaultSqliteJournalMode), dbSqliteJournalModeKey, sqliteJournalModes)
+	if err != nil {
+		return err
+	}
+	synchronous, err := sqliteOption(instance.getEnvFn(dbSqliteSynchronousKey).AsStringDefault(defaultSqliteSynchronous), dbSqliteSynchronousKey, sqliteSynchronousModes)
+	if err != nil {
+		return err
+	}
+	txLock, err := sqliteOption(instance.getEnvFn(dbSqliteTxLockKey).AsStringDefault(defaultSqliteTxLock), dbSqliteTxLockKey, sqliteTxLocks)
+	if err != nil {
+		return err
+	}
+	foreignKeys := 0
+	if instance.getEnvFn(dbSqliteForeignKeysKey).AsBoolDefault(true) {
+		foreignKeys = 1
+	}
+	busyTimeout := instance.getEnvFn(dbSqliteBusyTimeoutKey).AsDurationDefault(defaultSqliteBusyTimeout)
+
+	dsn := withSqliteParam(config.dsn, "_pragma", "busy_timeout", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	dsn = withSqliteParam(dsn, "_pragma", "journal_mode", "journal_mode("+journalMode+")")
+	dsn = withSqliteParam(dsn, "_pragma", "synchronous", "synchronous("+synchronous+")")
+	dsn = withSqliteParam(dsn, "_pragma", "foreign_keys", fmt.Sprintf("foreign_keys(%d)", foreignKeys))
+	config.dsn = withSqliteParam(dsn, "_txlock", "", strings.ToLower(txLock))
+
+	if instance.getEnvFn(dbSqliteSingleWriterKey).AsBoolDefault(false) {
+		if privateSqliteMemory(config.dsn) {
+			return fmt.Errorf("%s requires a file or a shared cache (cache=shared) in-memory database", dbSqliteSingleWriterKey)
+		}
+		instance.sqliteSingleWriter = true
+	}
+	return nil
+}
+
+// privateSqliteMemory reports whether every connection of the pool gets its own in-memory database
+func privateSqliteMemory(dsn string) bool {
+	path, query, _ := strings.Cut(dsn, "?")
+	if path == ":memory:" {
+		return true
+	}
+	values, _ := url.ParseQuery(query)
+	memory := path == "file::memory:" || values.Get("mode") == "memory"
+	return memory && values.Get("cache") != "shared"
+}
+
+func sqliteOption(value string, key string, allowed []string) (string, error) {
+	value = strings.ToUpper(value)
+	for _, option := range allowed {
+		if value == option {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("unknown %s %q, expected one of %s", key, value, strings.Join(allowed, ", "))
+}
+
+func withSqliteParam(dsn string, param string, prefix string, value string) string {
+	_, query, _ := strings.Cut(dsn, "?")
+	if values, err := url.ParseQuery(query); err == nil {
+		for _, existing := range values[param] {
+			if strings.HasPrefix(strings.ToLower(existing), prefix) {
+				return dsn
+			}
+		}
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + param + "=" + value
+}
+
+func (instance *connection) openSqliteReader(dsn string) (*gorm.DB, error) {
+	db, err := instance.open(dsn, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Use(&sqliteWriter{writer: instance.primary}); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteWriter sends statements modifying data outside a transaction from the reader pool to the single writer
+type sqliteWriter struct {
+	writer func() (*gorm.DB, error)
+}
+
+func (w *sqliteWriter) Name() string {
+	return sqliteWriterPluginName
+}
+
+func (w *sqliteWriter) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		name   string
+		before func(name string, fn func(*gorm.DB)) error
+		after  func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("gorm:begin_transaction").Register, callback.Create().After("gorm:commit_or_rollback_transaction").Register},
+		{"update", callback.Update().Before("gorm:begin_transaction").Register, callback.Update().After("gorm:commit_or_rollback_transaction").Register},
+		{"delete", callback.Delete().Before("gorm:begin_transaction").Register, callback.Delete().After("gorm:commit_or_rollback_transaction").Register},
+		{"raw", callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+	for _, processor := range processors {
+		if err := processor.before(sqliteWriterPluginName+"_before_"+processor.name, w.route); err != nil {
+			return err
+		}
+		if err := processor.after(sqliteWriterPluginName+"_after_"+processor.name, w.restore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *sqliteWriter) route(db *gorm.DB) {
+	if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); inTx {
+		return
+	}
+	writer, err := w.writer()
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	db.InstanceSet(sqliteReaderPoolKey, db.Statement.ConnPool)
+	db.Statement.ConnPool = writer.ConnPool
+}
+
+func (w *sqliteWriter) restore(db *gorm.DB) {
+	value, _ := db.InstanceGet(sqliteReaderPoolKey)
+	if pool, found := value.(gorm.ConnPool); found {
+		db.Statement.ConnPool = pool
+		db.InstanceSet(sqliteReaderPoolKey, nil)
+	}
+}
+
+func (instance *connection) openPrimary(dsn string) (*gorm.DB, error) {
+	db, err := instance.open(dsn, true)
+	if err != nil || !instance.sqliteSingleWriter {
+		return db, err
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDb.SetMaxOpenConns(1)
+	return db, nil
+}
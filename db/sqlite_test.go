@@ -0,0 +1,264 @@
+package db
+
+import (
+	gm "github.com/onsi/gomega"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type stressCounter struct {
+	Id    int64 `gorm:"primaryKey"`
+	Value int64
+}
+
+type stressEvent struct {
+	Id     int64 `gorm:"primaryKey"`
+	Worker int
+}
+
+func Test_SqliteDsn(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	t.Setenv("SQLITEDSN_DB_SQLITE_PATH", "/var/data/app.db?_pragma=busy_timeout(100)")
+	t.Setenv("SQLITEDSN_DB_SQLITE_SYNCHRONOUS", "full")
+	instance := NewConnection("test", "SQLITEDSN", false, false).(*connection)
+	config, err := instance.resolveConfig()
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(instance.configureSQLite(config)).Should(gm.BeNil())
+	gm.Expect(config.dsn).Should(gm.Equal("/var/data/app.db?_pragma=busy_timeout(100)&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)&_pragma=foreign_keys(1)&_txlock=immediate"))
+	gm.Expect(instance.sqliteSingleWriter).Should(gm.BeFalse())
+	gm.Expect(config.replicas).Should(gm.BeEmpty())
+
+	t.Setenv("SQLITEDSN_DB_SQLITE_JOURNAL_MODE", "fast")
+	gm.Expect(instance.configureSQLite(config)).Should(gm.MatchError(`unknown DB_SQLITE_JOURNAL_MODE "FAST", expected one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL, OFF`))
+}
+
+func Test_SqliteSingleWriterMemory(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	t.Setenv("SQLITEMEM_DB_SQLITE_SINGLE_WRITER", "true")
+	for dsn, allowed := range map[string]bool{
+		":memory:":                          false,
+		"file::memory:":                     false,
+		"file:app?mode=memory":              false,
+		"file::memory:?cache=shared":        true,
+		"file:app?mode=memory&cache=shared": true,
+		"/var/data/app.db":                  true,
+	} {
+		t.Setenv("SQLITEMEM_DB_SQLITE_PATH", dsn)
+		instance := NewConnection("test", "SQLITEMEM", false, false).(*connection)
+		config, err := instance.resolveConfig()
+		gm.Expect(err).Should(gm.BeNil())
+		if allowed {
+			gm.Expect(instance.configureSQLite(config)).Should(gm.BeNil(), dsn)
+		} else {
+			gm.Expect(instance.configureSQLite(config)).Should(gm.MatchError("DB_SQLITE_SINGLE_WRITER requires a file or a shared cache (cache=shared) in-memory database"), dsn)
+		}
+	}
+}
+
+func Test_SqliteSingleWriterRouting(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("ROUTING_TEST_DB_SQLITE_PATH", filepath.Join(t.TempDir(), "routing.db"))
+	_ = os.Setenv("ROUTING_TEST_DB_SQLITE_SINGLE_WRITER", "true")
+
+	conn := NewConnection("routing_test", "routing_test", false, false)
+	conn.Init()
+	defer func() { _ = conn.(io.Closer).Close() }()
+	conn.AutoMigrate(&stressCounter{})
+	gm.Expect(conn.Info().Replicas).Should(gm.BeEmpty())
+	gm.Expect(conn.Health().Components).Should(gm.BeEmpty())
+
+	instance := conn.(*connection)
+	writer, err := instance.primary()
+	gm.Expect(err).Should(gm.BeNil())
+	var pools []gorm.ConnPool
+	record := func(db *gorm.DB) { pools = append(pools, db.Statement.ConnPool) }
+	gm.Expect(instance.sqliteReader.Callback().Create().After("gorm:create").Before(sqliteWriterPluginName+"_after_create").Register("test:record_create", record)).Should(gm.BeNil())
+	gm.Expect(instance.sqliteReader.Callback().Raw().After("gorm:raw").Before(sqliteWriterPluginName+"_after_raw").Register("test:record_raw", record)).Should(gm.BeNil())
+	gm.Expect(instance.sqliteReader.Callback().Query().After("gorm:query").Register("test:record_query", record)).Should(gm.BeNil())
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		gm.Expect(session.Session(&gorm.Session{SkipDefaultTransaction: true}).Create(&stressCounter{Id: 1}).Error).Should(gm.BeNil())
+		gm.Expect(session.Exec("UPDATE stress_counters SET value = 1").Error).Should(gm.BeNil())
+		var counter stressCounter
+		return session.First(&counter, 1).Error
+	})).Should(gm.BeNil())
+	gm.Expect(pools).Should(gm.HaveLen(3))
+	gm.Expect(pools[0]).Should(gm.BeIdenticalTo(writer.ConnPool))
+	gm.Expect(pools[1]).Should(gm.BeIdenticalTo(writer.ConnPool))
+	gm.Expect(pools[2]).ShouldNot(gm.BeIdenticalTo(writer.ConnPool))
+}
+
+func Test_SqlitePragmas(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("PRAGMA_TEST_DB_SQLITE_PATH", filepath.Join(t.TempDir(), "pragma.db"))
+
+	conn := NewConnection("pragma_test", "pragma_test", false, false)
+	conn.Init()
+	defer func() { _ = conn.(io.Closer).Close() }()
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		var journalMode, synchronous string
+		var foreignKeys, busyTimeout int
+		gm.Expect(session.Raw("PRAGMA journal_mode").Scan(&journalMode).Error).Should(gm.BeNil())
+		gm.Expect(session.Raw("PRAGMA synchronous").Scan(&synchronous).Error).Should(gm.BeNil())
+		gm.Expect(session.Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error).Should(gm.BeNil())
+		gm.Expect(session.Raw("PRAGMA busy_timeout").Scan(&busyTimeout).Error).Should(gm.BeNil())
+		gm.Expect(journalMode).Should(gm.Equal("wal"))
+		gm.Expect(synchronous).Should(gm.Equal("1"))
+		gm.Expect(foreignKeys).Should(gm.Equal(1))
+		gm.Expect(busyTimeout).Should(gm.Equal(5000))
+		return nil
+	})).Should(gm.BeNil())
+}
+
+func Test_SqliteConcurrentWrites(t *testing.T) {
+	for _, singleWriter := range []string{"false", "true"} {
+		singleWriter := singleWriter
+		t.Run("single_writer_"+singleWriter, func(t *testing.T) {
+			g := gm.NewWithT(t)
+
+			_ = os.Setenv("STRESS_TEST_DB_SQLITE_PATH", filepath.Join(t.TempDir(), "stress.db"))
+			_ = os.Setenv("STRESS_TEST_DB_SQLITE_SINGLE_WRITER", singleWriter)
+			_ = os.Setenv("STRESS_TEST_DB_MAX_OPEN_CONNS", "8")
+
+			conn := NewConnection("stress_test", "stress_test", false, false)
+			conn.Init()
+			defer func() { _ = conn.(io.Closer).Close() }()
+			conn.AutoMigrate(&stressCounter{}, &stressEvent{})
+			g.Expect(conn.Session(func(session *Session) error {
+				return session.Create(&stressCounter{Id: 1}).Error
+			})).Should(gm.BeNil())
+
+			const workers = 16
+			const iterations = 25
+			errs := make(chan error, workers*iterations*2)
+			wg := sync.WaitGroup{}
+			for worker := 0; worker < workers; worker++ {
+				wg.Add(2)
+				go func(worker int) {
+					defer wg.Done()
+					for i := 0; i < iterations; i++ {
+						errs <- conn.Session(func(session *Session) error {
+							return session.Tx(func(session *Session) error {
+								var counter stressCounter
+								if err := session.First(&counter, 1).Error; err != nil {
+									return err
+								}
+								if err := session.Model(&counter).Update("value", counter.Value+1).Error; err != nil {
+									return err
+								}
+								return session.Create(&stressEvent{Worker: worker}).Error
+							})
+						})
+					}
+				}(worker)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < iterations; i++ {
+						errs <- conn.ReadSession(func(session *Session) error {
+							var count int64
+							return session.Model(&stressEvent{}).Count(&count).Error
+						})
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				g.Expect(err).Should(gm.BeNil())
+			}
+
+			g.Expect(conn.Session(func(session *Session) error {
+				var counter stressCounter
+				g.Expect(session.First(&counter, 1).Error).Should(gm.BeNil())
+				g.Expect(counter.Value).Should(gm.Equal(int64(workers * iterations)))
+				var events int64
+				g.Expect(session.Model(&stressEvent{}).Count(&events).Error).Should(gm.BeNil())
+				g.Expect(events).Should(gm.Equal(int64(workers * iterations)))
+				return nil
+			})).Should(gm.BeNil())
+		})
+	}
+}
+
+func Test_SqliteStreamingWrites(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("STREAM_STRESS_TEST_DB_SQLITE_PATH", filepath.Join(t.TempDir(), "stream.db"))
+	_ = os.Setenv("STREAM_STRESS_TEST_DB_SQLITE_SINGLE_WRITER", "true")
+	_ = os.Setenv("STREAM_STRESS_TEST_DB_MAX_OPEN_CONNS", "4")
+
+	conn := NewConnection("stream_stress_test", "stream_stress_test", false, false)
+	conn.Init()
+	defer func() { _ = conn.(io.Closer).Close() }()
+	conn.AutoMigrate(&stressCounter{}, &stressEvent{})
+
+	events := make([]stressEvent, 0)
+	for i := 0; i < 200; i++ {
+		events = append(events, stressEvent{Worker: i})
+	}
+	gm.Expect(conn.Session(func(session *Session) error {
+		if err := session.Create(&stressCounter{Id: 1}).Error; err != nil {
+			return err
+		}
+		return session.CreateInBatches(&events, 50).Error
+	})).Should(gm.BeNil())
+
+	increment := func() error {
+		return conn.Session(func(session *Session) error {
+			return session.Tx(func(session *Session) error {
+				return session.Model(&stressCounter{Id: 1}).Update("value", gorm.Expr("value + 1")).Error
+			})
+		})
+	}
+
+	writerErrs := make(chan error, 100)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			writerErrs <- increment()
+		}
+	}()
+
+	gm.Expect(runWithTestTimeout(func() error {
+		return SessionCursorStream[stressEvent](conn, 5, func(session *gorm.DB) *gorm.DB {
+			return session.Order("id")
+		}).ForEachChanElem(func(stressEvent) error {
+			return increment()
+		})
+	})).Should(gm.BeNil())
+
+	gm.Expect(runWithTestTimeout(func() error {
+		return conn.Session(func(session *Session) error {
+			return session.Tx(func(session *Session) error {
+				var counter stressCounter
+				if err := session.First(&counter, 1).Error; err != nil {
+					return err
+				}
+				return increment()
+			}, &TxOptions{ReadOnly: true})
+		})
+	})).Should(gm.BeNil())
+
+	wg.Wait()
+	close(writerErrs)
+	for err := range writerErrs {
+		gm.Expect(err).Should(gm.BeNil())
+	}
+	gm.Expect(conn.ReadSession(func(session *Session) error {
+		var counter stressCounter
+		gm.Expect(session.First(&counter, 1).Error).Should(gm.BeNil())
+		gm.Expect(counter.Value).Should(gm.Equal(int64(301)))
+		return nil
+	})).Should(gm.BeNil())
+}
@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/sedmess/go-ctx-base/utils/channels"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const DefaultInsertBatchSize = 500
+
+// errCopyMixedDefaults means a database-generated column is set in some rows of a chunk only, which COPY can't express
+var errCopyMixedDefaults = errors.New("chunk mixes zero and set database-generated values")
+
+type StreamInsertProgress struct {
+	Rows    int64
+	Commits int
+	Elapsed time.Duration
+}
+
+type StreamInsertOptions struct {
+	BatchSize   int
+	CommitEvery int
+	OnConflict  *clause.OnConflict
+	// Copy loads rows with Postgres COPY, which skips gorm hooks. Without OnConflict, outside a transaction, on
+	// a pgx pool and for chunks setting database-generated columns in all rows or none only; otherwise rows are
+	// inserted in batches.
+	Copy       bool
+	OnProgress func(progress StreamInsertProgress)
+}
+
+func (o *StreamInsertOptions) withDefaults() StreamInsertOptions {
+	var options StreamInsertOptions
+	if o != nil {
+		options = *o
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultInsertBatchSize
+	}
+	if options.CommitEvery <= 0 {
+		options.CommitEvery = options.BatchSize
+	}
+	return options
+}
+
+func StreamInsert[T any](connection Connection, ch channels.StreamingChan[T], opts *StreamInsertOptions) (int64, error) {
+	return StreamInsertContext[T](context.Background(), connection, ch, opts)
+}
+
+func StreamInsertContext[T any](ctx context.Context, connection Connection, ch channels.StreamingChan[T], opts *StreamInsertOptions) (int64, error) {
+	options := opts.withDefaults()
+	progress := StreamInsertProgress{}
+	started := time.Now()
+
+	err := connection.SessionContext(ctx, func(session *Session) error {
+		chunk := make([]T, 0, options.CommitEvery)
+		commit := func() error {
+			if len(chunk) == 0 {
+				return nil
+			}
+			if err := insertChunk(session, chunk, options); err != nil {
+				return err
+			}
+			progress.Rows += int64(len(chunk))
+			progress.Commits++
+			progress.Elapsed = time.Since(started)
+			chunk = chunk[:0]
+			if options.OnProgress != nil {
+				options.OnProgress(progress)
+			}
+			return nil
+		}
+
+		err := ch.ForEachChanElem(func(data T) error {
+			chunk = append(chunk, data)
+			if len(chunk) >= options.CommitEvery {
+				return commit()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return commit()
+	})
+	if err != nil {
+		go func() {
+			for range ch {
+			}
+		}()
+	}
+	return progress.Rows, err
+}
+
+func insertChunk[T any](session *Session, chunk []T, options StreamInsertOptions) error {
+	if options.Copy && options.OnConflict == nil && session.Dialector.Name() == DriverPostgres {
+		if conn, found := copyConn(session); found {
+			if err := copyChunk(session, conn, chunk); !errors.Is(err, errCopyMixedDefaults) {
+				return err
+			}
+		}
+	}
+	return session.Tx(func(session *Session) error {
+		db := session.DB
+		if options.OnConflict != nil {
+			db = db.Clauses(*options.OnConflict)
+		}
+		return db.CreateInBatches(chunk, options.BatchSize).Error
+	})
+}
+
+func copyConn(session *Session) (*sql.Conn, bool) {
+	conn, ok := session.Statement.ConnPool.(*sql.Conn)
+	if !ok || session.InTx() {
+		return nil, false
+	}
+	isPgx := false
+	_ = conn.Raw(func(driverConn any) error {
+		_, isPgx = driverConn.(*stdlib.Conn)
+		return nil
+	})
+	return conn, isPgx
+}
+
+func copyChunk[T any](session *Session, conn *sql.Conn, chunk []T) error {
+	table, columns, rows, err := copyRows(session, chunk)
+	if err != nil {
+		return err
+	}
+	txContext, cancelFn := session.txContext()
+	defer cancelFn()
+	return conn.Raw(func(driverConn any) error {
+		return pgx.BeginFunc(txContext, driverConn.(*stdlib.Conn).Conn(), func(tx pgx.Tx) error {
+			_, err := tx.CopyFrom(txContext, pgx.Identifier(strings.Split(table, ".")), columns, pgx.CopyFromRows(rows))
+			return err
+		})
+	})
+}
+
+func copyRows[T any](session *Session, chunk []T) (string, []string, [][]any, error) {
+	stmt := &gorm.Statement{DB: session.DB}
+	if err := stmt.Parse(new(T)); err != nil {
+		return "", nil, nil, err
+	}
+	now := stmt.DB.NowFunc()
+	values := make([]reflect.Value, len(chunk))
+	for i := range chunk {
+		values[i] = reflect.ValueOf(&chunk[i]).Elem()
+	}
+
+	var fields []*schema.Field
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || !field.Creatable {
+			continue
+		}
+		if field.HasDefaultValue && field.DefaultValueInterface == nil {
+			if zeros := countZero(session.Context, field, values); zeros == len(values) {
+				continue
+			} else if zeros > 0 {
+				return "", nil, nil, errCopyMixedDefaults
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = field.DBName
+	}
+	rows := make([][]any, len(values))
+	for i, value := range values {
+		row := make([]any, len(fields))
+		for j, field := range fields {
+			fieldValue, zero := field.ValueOf(session.Context, value)
+			if zero {
+				if generated, found := generatedTime(field, now); found {
+					fieldValue = generated
+				} else if field.DefaultValueInterface != nil {
+					fieldValue = field.DefaultValueInterface
+				}
+			}
+			row[j] = fieldValue
+		}
+		rows[i] = row
+	}
+	return stmt.Schema.Table, columns, rows, nil
+}
+
+func countZero(ctx context.Context, field *schema.Field, values []reflect.Value) int {
+	zeros := 0
+	for _, value := range values {
+		if _, zero := field.ValueOf(ctx, value); zero {
+			zeros++
+		}
+	}
+	return zeros
+}
+
+func generatedTime(field *schema.Field, now time.Time) (any, bool) {
+	kind := field.AutoCreateTime
+	if kind == 0 {
+		kind = field.AutoUpdateTime
+	}
+	switch kind {
+	case 0:
+		return nil, false
+	case schema.UnixNanosecond:
+		return now.UnixNano(), true
+	case schema.UnixMillisecond:
+		return now.UnixMilli(), true
+	case schema.UnixSecond:
+		return now.Unix(), true
+	default:
+		return now, true
+	}
+}
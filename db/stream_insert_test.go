@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"github.com/sedmess/go-ctx-base/utils/channels"
+	"gorm.io/gorm/clause"
+	"os"
+	"testing"
+	"time"
+)
+
+type importedItem struct {
+	Id      int64 `gorm:"primaryKey"`
+	Code    string
+	Value   int
+	Created time.Time `gorm:"autoCreateTime"`
+}
+
+func importedItems(from int, to int) []importedItem {
+	items := make([]importedItem, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		items = append(items, importedItem{Id: int64(i), Code: "code", Value: i})
+	}
+	return items
+}
+
+func Test_StreamInsert(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("STREAM_INSERT_TEST_DB_SQLITE_PATH", "file:stream_insert_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("stream_insert_test", "stream_insert_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&importedItem{})
+
+	var progress []StreamInsertProgress
+	inserted, err := StreamInsert[importedItem](conn, channels.SliceToChannel(importedItems(1, 25)), &StreamInsertOptions{
+		BatchSize:   4,
+		CommitEvery: 10,
+		OnProgress: func(p StreamInsertProgress) {
+			progress = append(progress, p)
+		},
+	})
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(inserted).Should(gm.Equal(int64(25)))
+	gm.Expect(progress).Should(gm.HaveLen(3))
+	gm.Expect(progress[0].Rows).Should(gm.Equal(int64(10)))
+	gm.Expect(progress[2].Rows).Should(gm.Equal(int64(25)))
+	gm.Expect(progress[2].Commits).Should(gm.Equal(3))
+
+	updated := importedItems(20, 30)
+	for i := range updated {
+		updated[i].Value = -updated[i].Value
+	}
+	inserted, err = StreamInsert[importedItem](conn, channels.SliceToChannel(updated), &StreamInsertOptions{
+		OnConflict: &clause.OnConflict{Columns: []clause.Column{{Name: "id"}}, DoUpdates: clause.AssignmentColumns([]string{"value"})},
+	})
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(inserted).Should(gm.Equal(int64(11)))
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		var count int64
+		gm.Expect(session.Model(&importedItem{}).Count(&count).Error).Should(gm.BeNil())
+		gm.Expect(count).Should(gm.Equal(int64(30)))
+		var item importedItem
+		gm.Expect(session.First(&item, 22).Error).Should(gm.BeNil())
+		gm.Expect(item.Value).Should(gm.Equal(-22))
+		gm.Expect(item.Created.IsZero()).Should(gm.BeFalse())
+		return nil
+	})).Should(gm.BeNil())
+
+	failure := errors.New("source failed")
+	source := channels.CreateChannel(func(sink func(data importedItem, context context.Context) bool) error {
+		for _, item := range importedItems(100, 104) {
+			sink(item, context.Background())
+		}
+		return failure
+	})
+	inserted, err = StreamInsert[importedItem](conn, source, &StreamInsertOptions{CommitEvery: 2})
+	gm.Expect(err).Should(gm.Equal(failure))
+	gm.Expect(inserted).Should(gm.Equal(int64(4)))
+}
+
+func Test_CopyRows(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("COPY_ROWS_TEST_DB_SQLITE_PATH", "file:copy_rows_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("copy_rows_test", "copy_rows_test", false, false)
+	conn.Init()
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		table, columns, rows, err := copyRows(session, []importedItem{{Code: "a", Value: 1}, {Code: "b", Value: 2}})
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(table).Should(gm.Equal("imported_items"))
+		gm.Expect(columns).Should(gm.Equal([]string{"code", "value", "created"}))
+		gm.Expect(rows).Should(gm.HaveLen(2))
+		gm.Expect(rows[1][0]).Should(gm.Equal("b"))
+		gm.Expect(rows[1][2].(time.Time).IsZero()).Should(gm.BeFalse())
+
+		_, columns, _, err = copyRows(session, importedItems(1, 1))
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(columns).Should(gm.Equal([]string{"id", "code", "value", "created"}))
+
+		_, _, _, err = copyRows(session, append(importedItems(1, 1), importedItem{Code: "generated"}))
+		gm.Expect(err).Should(gm.Equal(errCopyMixedDefaults))
+
+		_, found := copyConn(session)
+		gm.Expect(found).Should(gm.BeFalse())
+		return session.Tx(func(session *Session) error {
+			_, found := copyConn(session)
+			gm.Expect(found).Should(gm.BeFalse())
+			return nil
+		})
+	})).Should(gm.BeNil())
+}
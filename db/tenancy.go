@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+)
+
+const tenancyPluginName = "ctx_base:tenancy"
+
+var ErrTenantRequired = errors.New("tenant is required for DB session")
+var ErrInvalidTenant = errors.New("invalid tenant ID")
+
+var tenantIdRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{1,48}$`)
+
+type tenantKey struct{}
+
+type TenantResolver func(ctx context.Context) (string, bool)
+
+type TenancyOptions struct {
+	Resolver TenantResolver
+	Required bool
+}
+
+// WithTenancy scopes sessions to the tenant resolved from the session context. Postgres sessions switch search_path
+// to the tenant schema; SQLite prefixes the table of model based statements with "<tenant>_", while raw Exec/Raw SQL
+// and joined tables are sent as written and have to use Session.Tenant to name tenant tables.
+func WithTenancy(options TenancyOptions) ConnectionOption {
+	return func(instance *connection) {
+		instance.tenancy = &tenancy{resolver: options.Resolver, required: options.Required}
+	}
+}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, found := ctx.Value(tenantKey{}).(string)
+	return tenant, found && tenant != ""
+}
+
+func ForEachTenant(ctx context.Context, connection Connection, tenants []string, fn func(session *Session) error) error {
+	for _, tenant := range tenants {
+		if err := connection.SessionContext(WithTenant(ctx, tenant), fn); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+func AutoMigrateTenants(connection Connection, tenants []string, models ...any) error {
+	return ForEachTenant(context.Background(), connection, tenants, func(session *Session) error {
+		if session.Dialector.Name() == DriverPostgres {
+			if err := session.Exec("CREATE SCHEMA IF NOT EXISTS " + quoteTenant(session.tenant)).Error; err != nil {
+				return err
+			}
+		}
+		return session.Tx(func(session *Session) error {
+			if session.Dialector.Name() != DriverSQLite {
+				return session.AutoMigrate(models...)
+			}
+			for _, model := range models {
+				stmt := &gorm.Statement{DB: session.DB}
+				if err := stmt.Parse(model); err != nil {
+					return err
+				}
+				if err := session.Table(tenantTable(session.tenant, stmt.Schema.Table)).AutoMigrate(model); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+type tenancy struct {
+	resolver TenantResolver
+	required bool
+}
+
+func (t *tenancy) resolve(ctx context.Context) (string, error) {
+	tenant, found := TenantFromContext(ctx)
+	if !found && t.resolver != nil {
+		tenant, found = t.resolver(ctx)
+	}
+	if !found || tenant == "" {
+		if t.required {
+			return "", ErrTenantRequired
+		}
+		return "", nil
+	}
+	if !tenantIdRegexp.MatchString(tenant) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTenant, tenant)
+	}
+	return tenant, nil
+}
+
+func (t *tenancy) Name() string {
+	return tenancyPluginName
+}
+
+func (t *tenancy) Initialize(db *gorm.DB) error {
+	if db.Dialector.Name() != DriverSQLite {
+		return nil
+	}
+	callback := db.Callback()
+	registrations := []func(name string, fn func(*gorm.DB)) error{
+		callback.Create().Before("gorm:create").Register,
+		callback.Query().Before("gorm:query").Register,
+		callback.Update().Before("gorm:update").Register,
+		callback.Delete().Before("gorm:delete").Register,
+		callback.Row().Before("gorm:row").Register,
+	}
+	for i, register := range registrations {
+		if err := register(fmt.Sprintf("%s_prefix_%d", tenancyPluginName, i), t.prefixTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tenancy) prefixTable(db *gorm.DB) {
+	if db.Statement.Context == nil || db.Statement.Table == "" || db.Statement.TableExpr != nil {
+		return
+	}
+	tenant, err := t.resolve(db.Statement.Context)
+	if err != nil {
+		if !errors.Is(err, ErrTenantRequired) {
+			_ = db.AddError(err)
+		}
+		return
+	}
+	if tenant != "" && !strings.HasPrefix(db.Statement.Table, tenant+"_") {
+		db.Statement.Table = tenantTable(tenant, db.Statement.Table)
+	}
+}
+
+func (instance *connection) openSession(baseContext context.Context, db *gorm.DB, primary *gorm.DB, guard bool, dbFunc func(session *Session) error) error {
+	tenant := ""
+	if instance.tenancy != nil {
+		var err error
+		if tenant, err = instance.tenancy.resolve(baseContext); err != nil && (guard || !errors.Is(err, ErrTenantRequired)) {
+			return err
+		}
+	}
+	// the SQLite single-writer reader pool serves read-only transactions, so they don't hold the only writer
+	readOnlyLocal := instance.sqliteReader != nil && db == instance.sqliteReader
+	// query deadlines of rows read after their callback are released with the session at the latest
+	baseContext, cancelFn := context.WithCancel(baseContext)
+	defer cancelFn()
+	return db.Connection(func(db *gorm.DB) error {
+		session := newSession(baseContext, db)
+		if primary != nil {
+			session.primary = primary.WithContext(baseContext)
+			session.readOnlyLocal = readOnlyLocal
+		}
+		session.tenant = tenant
+		if tenant != "" && db.Dialector.Name() == DriverPostgres {
+			if err := session.Exec("SET search_path TO " + quoteTenant(tenant)).Error; err != nil {
+				return err
+			}
+			defer func() {
+				if err := db.WithContext(context.Background()).Exec("RESET search_path").Error; err != nil {
+					instance.logger.Error("on resetting search_path, dropping connection:", err)
+					discardConn(session)
+				}
+			}()
+		}
+		return dbFunc(session)
+	})
+}
+
+func (s *Session) Tenant() string {
+	return s.tenant
+}
+
+func (s *Session) setLocalTenant(tx *gorm.DB) error {
+	if s.tenant == "" || tx.Dialector.Name() != DriverPostgres {
+		return nil
+	}
+	return tx.Exec("SET LOCAL search_path TO " + quoteTenant(s.tenant)).Error
+}
+
+func quoteTenant(tenant string) string {
+	return `"` + tenant + `"`
+}
+
+func tenantTable(tenant string, table string) string {
+	return tenant + "_" + table
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"os"
+	"testing"
+)
+
+type tenantItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+func Test_Tenancy(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("TENANCY_TEST_DB_SQLITE_PATH", "file:tenancy_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("tenancy_test", "tenancy_test", false, false, WithTenancy(TenancyOptions{Required: true}))
+	conn.Init()
+
+	gm.Expect(AutoMigrateTenants(conn, []string{"acme", "globex"}, &tenantItem{})).Should(gm.BeNil())
+
+	for _, tenant := range []string{"acme", "globex"} {
+		gm.Expect(conn.SessionContext(WithTenant(context.Background(), tenant), func(session *Session) error {
+			gm.Expect(session.Tenant()).Should(gm.Equal(tenant))
+			return session.Tx(func(session *Session) error {
+				return session.Create(&tenantItem{Id: 1, Name: tenant}).Error
+			})
+		})).Should(gm.BeNil())
+	}
+
+	var item tenantItem
+	gm.Expect(conn.SessionContext(WithTenant(context.Background(), "globex"), func(session *Session) error {
+		var count int64
+		if err := session.Model(&tenantItem{}).Count(&count).Error; err != nil {
+			return err
+		}
+		gm.Expect(count).Should(gm.Equal(int64(1)))
+		return session.First(&item).Error
+	})).Should(gm.BeNil())
+	gm.Expect(item.Name).Should(gm.Equal("globex"))
+
+	err := conn.Session(func(session *Session) error { return nil })
+	gm.Expect(errors.Is(err, ErrTenantRequired)).Should(gm.BeTrue())
+
+	err = conn.SessionContext(WithTenant(context.Background(), "acme; drop table x"), func(session *Session) error { return nil })
+	gm.Expect(errors.Is(err, ErrInvalidTenant)).Should(gm.BeTrue())
+}
+
+func Test_TenantResolver(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	type requestTenantKey struct{}
+	_ = os.Setenv("RESOLVER_TEST_DB_SQLITE_PATH", "file:resolver_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("resolver_test", "resolver_test", false, false, WithTenancy(TenancyOptions{
+		Resolver: func(ctx context.Context) (string, bool) {
+			tenant, found := ctx.Value(requestTenantKey{}).(string)
+			return tenant, found
+		},
+	}))
+	conn.Init()
+	conn.AutoMigrate(&tenantItem{})
+	gm.Expect(AutoMigrateTenants(conn, []string{"initech"}, &tenantItem{})).Should(gm.BeNil())
+
+	gm.Expect(conn.SessionContext(context.WithValue(context.Background(), requestTenantKey{}, "initech"), func(session *Session) error {
+		return session.Create(&tenantItem{Id: 1, Name: "initech"}).Error
+	})).Should(gm.BeNil())
+
+	var shared []tenantItem
+	gm.Expect(conn.Session(func(session *Session) error {
+		return session.Find(&shared).Error
+	})).Should(gm.BeNil())
+	gm.Expect(shared).Should(gm.BeEmpty())
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+const timeoutsPluginName = "ctx_base:timeouts"
+const timeoutStateKey = "ctx_base:timeout_state"
+
+type queryTimeoutKey struct{}
+type txTimeoutKey struct{}
+
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, timeout)
+}
+
+func WithTxTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, txTimeoutKey{}, timeout)
+}
+
+func WithoutTimeouts(ctx context.Context) context.Context {
+	return WithTxTimeout(WithQueryTimeout(ctx, 0), 0)
+}
+
+func streamContext(ctx context.Context) context.Context {
+	if _, found := ctx.Value(queryTimeoutKey{}).(time.Duration); !found {
+		ctx = WithQueryTimeout(ctx, 0)
+	}
+	if _, found := ctx.Value(txTimeoutKey{}).(time.Duration); !found {
+		ctx = WithTxTimeout(ctx, 0)
+	}
+	return ctx
+}
+
+func effectiveTimeout(ctx context.Context, key any, def time.Duration) time.Duration {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline {
+		return 0
+	}
+	if timeout, found := ctx.Value(key).(time.Duration); found {
+		return timeout
+	}
+	return def
+}
+
+type timeoutState struct {
+	original context.Context
+	cancelFn context.CancelFunc
+}
+
+type timeoutsPlugin struct {
+	queryTimeout time.Duration
+	txTimeout    time.Duration
+}
+
+func (p *timeoutsPlugin) Name() string {
+	return timeoutsPluginName
+}
+
+func (p *timeoutsPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		name   string
+		before func(name string, fn func(*gorm.DB)) error
+		after  func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("gorm:create").Register, callback.Create().After("gorm:create").Register},
+		{"query", callback.Query().Before("gorm:query").Register, callback.Query().After("gorm:query").Register},
+		{"update", callback.Update().Before("gorm:update").Register, callback.Update().After("gorm:update").Register},
+		{"delete", callback.Delete().Before("gorm:delete").Register, callback.Delete().After("gorm:delete").Register},
+		{"raw", callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+	for _, processor := range processors {
+		if err := processor.before(timeoutsPluginName+"_before_"+processor.name, p.before); err != nil {
+			return err
+		}
+		if err := processor.after(timeoutsPluginName+"_after_"+processor.name, p.after); err != nil {
+			return err
+		}
+	}
+	if err := callback.Row().Before("gorm:row").Register(timeoutsPluginName+"_before_row", p.before); err != nil {
+		return err
+	}
+	return callback.Row().After("gorm:row").Register(timeoutsPluginName+"_after_row", p.afterRow)
+}
+
+func (p *timeoutsPlugin) before(db *gorm.DB) {
+	original := db.Statement.Context
+	ctx := original
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout := effectiveTimeout(ctx, queryTimeoutKey{}, p.queryTimeout); timeout > 0 {
+		timeoutContext, cancelFn := context.WithTimeout(ctx, timeout)
+		db.Statement.Context = timeoutContext
+		db.InstanceSet(timeoutStateKey, &timeoutState{original: original, cancelFn: cancelFn})
+	}
+}
+
+// after restores the statement context, so a reused query builder doesn't run its next statement on a cancelled one
+func (p *timeoutsPlugin) after(db *gorm.DB) {
+	value, _ := db.InstanceGet(timeoutStateKey)
+	if state, found := value.(*timeoutState); found {
+		state.cancelFn()
+		db.Statement.Context = state.original
+		db.InstanceSet(timeoutStateKey, nil)
+	}
+}
+
+// afterRow only restores the statement context: the returned rows are read after the callback, so the deadline
+// is released when it expires, or together with the session context once the session ends
+func (p *timeoutsPlugin) afterRow(db *gorm.DB) {
+	value, _ := db.InstanceGet(timeoutStateKey)
+	if state, found := value.(*timeoutState); found {
+		db.Statement.Context = state.original
+		db.InstanceSet(timeoutStateKey, nil)
+	}
+}
+
+func (s *Session) txContext() (context.Context, context.CancelFunc) {
+	def := time.Duration(0)
+	if plugin, found := s.DB.Config.Plugins[timeoutsPluginName].(*timeoutsPlugin); found {
+		def = plugin.txTimeout
+	}
+	if timeout := effectiveTimeout(s.Context, txTimeoutKey{}, def); timeout > 0 {
+		return context.WithTimeout(s.Context, timeout)
+	}
+	return s.Context, func() {}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+	"time"
+)
+
+const countingQuery = "WITH RECURSIVE r(i) AS (VALUES(0) UNION ALL SELECT i + 1 FROM r LIMIT 1000000000) SELECT i FROM r;"
+const runawayQuery = "WITH RECURSIVE r(i) AS (VALUES(0) UNION ALL SELECT i FROM r LIMIT 1000000000) SELECT i FROM r WHERE i = 1;"
+
+func runWithTestTimeout(fn func() error) error {
+	resultCh := make(chan error, 1)
+	go func() {
+		resultCh <- fn()
+	}()
+	select {
+	case err := <-resultCh:
+		return err
+	case <-time.After(5 * time.Second):
+		return errors.New("test timeout")
+	}
+}
+
+func Test_QueryTimeout(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("TIMEOUT_TEST_DB_SQLITE_PATH", "file:timeout_test:?mode=memory&cache=shared")
+	_ = os.Setenv("TIMEOUT_TEST_DB_QUERY_TIMEOUT", "10ms")
+	_ = os.Setenv("TIMEOUT_TEST_DB_TX_TIMEOUT", "50ms")
+
+	conn := NewConnection("timeout_test", "timeout_test", false, false)
+	conn.Init()
+
+	err := runWithTestTimeout(func() error {
+		return conn.Session(func(session *Session) error {
+			return session.Exec(runawayQuery).Error
+		})
+	})
+	gm.Expect(err).Should(gm.MatchError("interrupted (9)"))
+
+	err = runWithTestTimeout(func() error {
+		return conn.Session(func(session *Session) error {
+			return session.Tx(func(session *Session) error {
+				_, hasDeadline := session.Deadline()
+				gm.Expect(hasDeadline).Should(gm.BeTrue())
+				return session.Exec(runawayQuery).Error
+			})
+		})
+	})
+	gm.Expect(err).Should(gm.MatchError("interrupted (9)"))
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		query := session.Table("sqlite_master").Where("1 = 1")
+		var count int64
+		gm.Expect(query.Count(&count).Error).Should(gm.BeNil())
+		time.Sleep(20 * time.Millisecond)
+		gm.Expect(query.Statement.Context.Err()).Should(gm.BeNil())
+		return query.Count(&count).Error
+	})).Should(gm.BeNil())
+
+	rowDeadline := false
+	gormDb, err := conn.(*connection).primary()
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(gormDb.Callback().Row().Before("gorm:row").After(timeoutsPluginName+"_before_row").Register("timeout_test:row", func(db *gorm.DB) {
+		_, rowDeadline = db.Statement.Context.Deadline()
+	})).Should(gm.BeNil())
+	gm.Expect(conn.Session(func(session *Session) error {
+		var value int
+		if err := session.Raw("select 1").Scan(&value).Error; err != nil {
+			return err
+		}
+		gm.Expect(value).Should(gm.Equal(1))
+		return nil
+	})).Should(gm.BeNil())
+	gm.Expect(rowDeadline).Should(gm.BeTrue())
+
+	err = runWithTestTimeout(func() error {
+		return conn.Session(func(session *Session) error {
+			rows, err := session.Raw(countingQuery).Rows()
+			if err != nil {
+				return err
+			}
+			defer func() { _ = rows.Close() }()
+			for rows.Next() {
+			}
+			return rows.Err()
+		})
+	})
+	gm.Expect(errors.Is(err, context.DeadlineExceeded)).Should(gm.BeTrue())
+
+	started := time.Now()
+	err = runWithTestTimeout(func() error {
+		return conn.SessionContext(WithQueryTimeout(context.Background(), 200*time.Millisecond), func(session *Session) error {
+			return session.Exec(runawayQuery).Error
+		})
+	})
+	gm.Expect(err).Should(gm.MatchError("interrupted (9)"))
+	gm.Expect(time.Since(started)).Should(gm.BeNumerically(">=", 200*time.Millisecond))
+
+	err = runWithTestTimeout(func() error {
+		return conn.SessionContext(WithoutTimeouts(context.Background()), func(session *Session) error {
+			return session.Tx(func(session *Session) error {
+				_, hasDeadline := session.Deadline()
+				gm.Expect(hasDeadline).Should(gm.BeFalse())
+				return session.Exec("select 1").Error
+			})
+		})
+	})
+	gm.Expect(err).Should(gm.BeNil())
+}
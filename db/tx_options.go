@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrTxOptionsConflict = errors.New("transaction options conflict with the outer transaction")
+var ErrTxOptionUnsupported = errors.New("transaction option is not supported by the dialect")
+
+type TxOptions struct {
+	Isolation  sql.IsolationLevel
+	ReadOnly   bool
+	Deferrable bool
+	Savepoint  bool
+}
+
+func firstTxOptions(opts []*TxOptions) *TxOptions {
+	for _, opt := range opts {
+		if opt != nil {
+			return opt
+		}
+	}
+	return nil
+}
+
+func (o *TxOptions) sqlTxOptions() *sql.TxOptions {
+	if o == nil {
+		return nil
+	}
+	return &sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly}
+}
+
+func (o *TxOptions) satisfiedBy(outer *TxOptions) error {
+	if o == nil {
+		return nil
+	}
+	if outer == nil {
+		outer = &TxOptions{}
+	}
+	if o.Isolation != sql.LevelDefault && o.Isolation != outer.Isolation {
+		return fmt.Errorf("%w: isolation %s requested, outer transaction uses %s", ErrTxOptionsConflict, o.Isolation, outer.Isolation)
+	}
+	if o.ReadOnly && !outer.ReadOnly {
+		return fmt.Errorf("%w: read-only requested, outer transaction is read-write", ErrTxOptionsConflict)
+	}
+	if o.Deferrable && !outer.Deferrable {
+		return fmt.Errorf("%w: deferrable requested, outer transaction is not deferrable", ErrTxOptionsConflict)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/sedmess/go-ctx/logger"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	sqliteBusy             = 5
+	sqliteLocked           = 6
+	mysqlLockWaitTimeout   = 1205
+	mysqlDeadlock          = 1213
+	sqlServerDeadlock      = 1205
+)
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		backoff = backoff*(1-jitter) + rand.Float64()*backoff*jitter
+	}
+	return time.Duration(backoff)
+}
+
+func IsRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+	}
+	var sqliteErr *gosqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqliteBusy || code == sqliteLocked
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlock || mysqlErr.Number == mysqlLockWaitTimeout
+	}
+	var sqlServerErr mssql.Error
+	if errors.As(err, &sqlServerErr) {
+		return sqlServerErr.Number == sqlServerDeadlock
+	}
+	return false
+}
+
+func (s *Session) TxRetry(policy RetryPolicy, txFunc func(session *Session) error, opts ...*TxOptions) error {
+	if s.inTx {
+		return s.Tx(txFunc, opts...)
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.Tx(txFunc, opts...)
+		if err == nil || !IsRetryableTxError(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		backoff := policy.Backoff(attempt)
+		if deadline, ok := s.Context.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		logger.Debug("DB", "retrying transaction after", backoff, "attempt", attempt, "failed:", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-s.Context.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func TxRetryReturning[T any](session *Session, policy RetryPolicy, txFn func(session *Session) (T, error), opts ...*TxOptions) (T, error) {
+	var value T
+	err := session.TxRetry(policy, func(session *Session) error {
+		var err error
+		value, err = txFn(session)
+		return err
+	}, opts...)
+	return value, err
+}
+
+func SessionTxRetry(connection Connection, policy RetryPolicy, txFn func(session *Session) error, opts ...*TxOptions) error {
+	return SessionContextTxRetry(context.Background(), connection, policy, txFn, opts...)
+}
+
+func SessionContextTxRetry(context context.Context, connection Connection, policy RetryPolicy, txFn func(session *Session) error, opts ...*TxOptions) error {
+	return connection.SessionContext(context, func(session *Session) error {
+		return session.TxRetry(policy, txFn, opts...)
+	})
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	gm "github.com/onsi/gomega"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_TxRetry(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("RETRY_TEST_DB_SQLITE_PATH", "file:retry_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("retry_test", "retry_test", false, false)
+	conn.Init()
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2, Jitter: 0.5}
+
+	attempts := 0
+	err := SessionTxRetry(conn, policy, func(session *Session) error {
+		attempts++
+		if attempts < 3 {
+			return &pgconn.PgError{Code: pgSerializationFailure}
+		}
+		return nil
+	})
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(attempts).Should(gm.Equal(3))
+
+	attempts = 0
+	err = SessionTxRetry(conn, policy, func(session *Session) error {
+		attempts++
+		return &pgconn.PgError{Code: pgDeadlockDetected}
+	})
+	gm.Expect(IsRetryableTxError(err)).Should(gm.BeTrue())
+	gm.Expect(attempts).Should(gm.Equal(3))
+
+	attempts = 0
+	err = SessionTxRetry(conn, policy, func(session *Session) error {
+		attempts++
+		return errors.New("not retryable")
+	})
+	gm.Expect(err).ShouldNot(gm.BeNil())
+	gm.Expect(attempts).Should(gm.Equal(1))
+}
@@ -0,0 +1,346 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+const upsertInsertedColumn = "ctx_base_inserted"
+
+var ErrUpsertColumns = errors.New("invalid upsert columns")
+var ErrUpsertDuplicateKey = errors.New("duplicate upsert conflict key")
+
+type UpsertOptions struct {
+	ConflictColumns []string
+	UpdateColumns   []string
+	ExceptColumns   []string
+	DoNothing       bool
+}
+
+type UpsertResult struct {
+	Inserted []int
+	Updated  []int
+	Skipped  []int
+}
+
+func Upsert[T any](session *Session, entity *T, options UpsertOptions) (bool, error) {
+	entities := []T{*entity}
+	result, err := UpsertBatch(session, entities, options)
+	if err != nil {
+		return false, err
+	}
+	*entity = entities[0]
+	return len(result.Inserted) == 1, nil
+}
+
+// UpsertBatch inserts entities or updates the rows they conflict with. Model hooks are not run on any dialect,
+// as a row can be either created or updated by the same statement. Entities of a batch must not share conflict
+// keys, except the zero values the database generates (e.g. auto-increment primary keys).
+func UpsertBatch[T any](session *Session, entities []T, options UpsertOptions) (UpsertResult, error) {
+	if len(entities) == 0 {
+		return UpsertResult{}, nil
+	}
+	stmt := &gorm.Statement{DB: session.DB}
+	if err := stmt.Parse(new(T)); err != nil {
+		return UpsertResult{}, err
+	}
+	conflictFields, onConflict, err := upsertClause(stmt.Schema, options)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	keys, err := upsertKeys(session, entities, conflictFields)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	var result UpsertResult
+	err = session.Tx(func(session *Session) error {
+		if session.Dialector.Name() == DriverPostgres {
+			result, err = upsertReturning(session, stmt.Schema, entities, keys, conflictFields, onConflict)
+		} else {
+			result, err = upsertPreSelect(session, entities, keys, conflictFields, onConflict)
+		}
+		return err
+	})
+	return result, err
+}
+
+func upsertClause(s *schema.Schema, options UpsertOptions) ([]*schema.Field, clause.OnConflict, error) {
+	lookup := func(names []string) ([]*schema.Field, error) {
+		fields := make([]*schema.Field, 0, len(names))
+		for _, name := range names {
+			field := s.LookUpField(name)
+			if field == nil || field.DBName == "" {
+				return nil, fmt.Errorf("%w: %s has no column %s", ErrUpsertColumns, s.Name, name)
+			}
+			fields = append(fields, field)
+		}
+		return fields, nil
+	}
+
+	conflictFields := s.PrimaryFields
+	if len(options.ConflictColumns) > 0 {
+		var err error
+		if conflictFields, err = lookup(options.ConflictColumns); err != nil {
+			return nil, clause.OnConflict{}, err
+		}
+	}
+	if len(conflictFields) == 0 {
+		return nil, clause.OnConflict{}, fmt.Errorf("%w: %s has no conflict columns", ErrUpsertColumns, s.Name)
+	}
+	onConflict := clause.OnConflict{Columns: make([]clause.Column, len(conflictFields))}
+	for i, field := range conflictFields {
+		onConflict.Columns[i] = clause.Column{Name: field.DBName}
+	}
+	if options.DoNothing {
+		onConflict.DoNothing = true
+		return conflictFields, onConflict, nil
+	}
+
+	var updateFields []*schema.Field
+	if len(options.UpdateColumns) > 0 {
+		var err error
+		if updateFields, err = lookup(options.UpdateColumns); err != nil {
+			return nil, clause.OnConflict{}, err
+		}
+	} else {
+		exceptFields, err := lookup(options.ExceptColumns)
+		if err != nil {
+			return nil, clause.OnConflict{}, err
+		}
+		excluded := make(map[string]bool)
+		for _, field := range append(exceptFields, conflictFields...) {
+			excluded[field.DBName] = true
+		}
+		for _, field := range s.Fields {
+			if field.DBName == "" || !field.Updatable || field.PrimaryKey || field.AutoCreateTime != 0 || excluded[field.DBName] {
+				continue
+			}
+			updateFields = append(updateFields, field)
+		}
+	}
+	if len(updateFields) == 0 {
+		return nil, clause.OnConflict{}, fmt.Errorf("%w: nothing to update in %s, use DoNothing instead", ErrUpsertColumns, s.Name)
+	}
+	columns := make([]string, len(updateFields))
+	for i, field := range updateFields {
+		columns[i] = field.DBName
+	}
+	onConflict.DoUpdates = clause.AssignmentColumns(columns)
+	return conflictFields, onConflict, nil
+}
+
+// upsertReturning matches RETURNING rows to entities by position: rows come back in the order of the VALUES list,
+// and DoNothing leaves out the skipped ones, so an entity either owns the next row or was skipped
+func upsertReturning[T any](session *Session, s *schema.Schema, entities []T, keys []upsertRowKey, conflictFields []*schema.Field, onConflict clause.OnConflict) (UpsertResult, error) {
+	stmt := upsertReturningStatement(session, s, entities, conflictFields, onConflict)
+	if stmt.Error != nil {
+		return UpsertResult{}, stmt.Error
+	}
+
+	rows, err := session.Raw(stmt.SQL.String(), stmt.Vars...).Rows()
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	var result UpsertResult
+	next := 0
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return UpsertResult{}, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			row[column] = values[i]
+		}
+		keyValues := make([]any, len(conflictFields))
+		for i, field := range conflictFields {
+			keyValues[i] = row[field.DBName]
+		}
+		rowKey := upsertKey(keyValues)
+		owner := upsertRowOwner(keys, next, rowKey)
+		if owner == len(entities) {
+			return UpsertResult{}, fmt.Errorf("upsert returned an unexpected row for key %q", rowKey)
+		}
+		for ; next < owner; next++ {
+			result.Skipped = append(result.Skipped, next)
+		}
+		value := reflect.ValueOf(&entities[next]).Elem()
+		for _, field := range s.PrimaryFields {
+			if err := field.Set(session.Context, value, row[field.DBName]); err != nil {
+				return UpsertResult{}, err
+			}
+		}
+		if inserted, _ := row[upsertInsertedColumn].(bool); inserted {
+			result.Inserted = append(result.Inserted, next)
+		} else {
+			result.Updated = append(result.Updated, next)
+		}
+		next++
+	}
+	if err := rows.Err(); err != nil {
+		return UpsertResult{}, err
+	}
+	for ; next < len(entities); next++ {
+		result.Skipped = append(result.Skipped, next)
+	}
+	return result, nil
+}
+
+// upsertRowOwner returns the first entity from next on that can own a RETURNING row with the key, or len(keys)
+func upsertRowOwner(keys []upsertRowKey, next int, rowKey string) int {
+	for next < len(keys) && !keys[next].generated && keys[next].key != rowKey {
+		next++
+	}
+	return next
+}
+
+func upsertReturningStatement[T any](session *Session, s *schema.Schema, entities []T, conflictFields []*schema.Field, onConflict clause.OnConflict) *gorm.Statement {
+	returning := clause.Returning{Columns: []clause.Column{{Name: "(xmax = 0) AS " + upsertInsertedColumn, Raw: true}}}
+	for _, field := range uniqueFields(s.PrimaryFields, conflictFields) {
+		returning.Columns = append(returning.Columns, clause.Column{Name: field.DBName})
+	}
+	return session.Session(&gorm.Session{DryRun: true, SkipHooks: true}).Clauses(onConflict, returning).Create(&entities).Statement
+}
+
+func upsertPreSelect[T any](session *Session, entities []T, keys []upsertRowKey, conflictFields []*schema.Field, onConflict clause.OnConflict) (UpsertResult, error) {
+	columns := make([]string, len(conflictFields))
+	for i, field := range conflictFields {
+		columns[i] = field.DBName
+	}
+	alternatives := make([]clause.Expression, 0, len(entities))
+	for i := range entities {
+		if keys[i].generated {
+			continue
+		}
+		value := reflect.ValueOf(&entities[i]).Elem()
+		conditions := make([]clause.Expression, len(conflictFields))
+		for j, field := range conflictFields {
+			fieldValue, _ := field.ValueOf(session.Context, value)
+			conditions[j] = clause.Eq{Column: clause.Column{Name: field.DBName}, Value: fieldValue}
+		}
+		alternatives = append(alternatives, clause.And(conditions...))
+	}
+	existing := make(map[string]bool)
+	if len(alternatives) > 0 {
+		rows, err := session.Model(new(T)).Select(columns).Where(clause.Or(alternatives...)).Rows()
+		if err != nil {
+			return UpsertResult{}, err
+		}
+		for rows.Next() {
+			values := make([]any, len(columns))
+			pointers := make([]any, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			if err := rows.Scan(pointers...); err != nil {
+				_ = rows.Close()
+				return UpsertResult{}, err
+			}
+			existing[upsertKey(values)] = true
+		}
+		if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+			return UpsertResult{}, err
+		}
+	}
+
+	if err := session.Session(&gorm.Session{SkipHooks: true}).Clauses(onConflict).Create(&entities).Error; err != nil {
+		return UpsertResult{}, err
+	}
+
+	var result UpsertResult
+	for i, key := range keys {
+		switch {
+		case key.generated || !existing[key.key]:
+			result.Inserted = append(result.Inserted, i)
+		case onConflict.DoNothing:
+			result.Skipped = append(result.Skipped, i)
+		default:
+			result.Updated = append(result.Updated, i)
+		}
+	}
+	return result, nil
+}
+
+// upsertRowKey is the conflict key of an entity; a generated key has a column the database fills in, so it never conflicts
+type upsertRowKey struct {
+	key       string
+	generated bool
+}
+
+// upsertKeys rejects entities sharing a conflict key, as a single statement can't both insert and update the same row
+func upsertKeys[T any](session *Session, entities []T, conflictFields []*schema.Field) ([]upsertRowKey, error) {
+	keys := make([]upsertRowKey, len(entities))
+	seen := make(map[string]int, len(entities))
+	for i := range entities {
+		value := reflect.ValueOf(&entities[i]).Elem()
+		keyValues := make([]any, len(conflictFields))
+		generated := false
+		for j, field := range conflictFields {
+			var isZero bool
+			keyValues[j], isZero = field.ValueOf(session.Context, value)
+			generated = generated || isZero && field.HasDefaultValue && field.DefaultValueInterface == nil
+		}
+		if generated {
+			keys[i].generated = true
+			continue
+		}
+		keys[i].key = upsertKey(keyValues)
+		if first, found := seen[keys[i].key]; found {
+			return nil, fmt.Errorf("%w: entities %d and %d", ErrUpsertDuplicateKey, first, i)
+		}
+		seen[keys[i].key] = i
+	}
+	return keys, nil
+}
+
+func upsertKey(values []any) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		if valuer, ok := value.(driver.Valuer); ok {
+			value, _ = valuer.Value()
+		}
+		if bytes, ok := value.([]byte); ok {
+			value = string(bytes)
+		}
+		if reflected := reflect.ValueOf(value); reflected.Kind() == reflect.Pointer {
+			if reflected.IsNil() {
+				value = nil
+			} else {
+				value = reflected.Elem().Interface()
+			}
+		}
+		parts[i] = fmt.Sprint(value)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func uniqueFields(groups ...[]*schema.Field) []*schema.Field {
+	seen := make(map[string]bool)
+	var result []*schema.Field
+	for _, group := range groups {
+		for _, field := range group {
+			if !seen[field.DBName] {
+				seen[field.DBName] = true
+				result = append(result, field)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"errors"
+	gm "github.com/onsi/gomega"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type upsertedItem struct {
+	Id      int64  `gorm:"primaryKey"`
+	Sku     string `gorm:"uniqueIndex"`
+	Name    string
+	Stock   int
+	Created time.Time `gorm:"autoCreateTime"`
+}
+
+var upsertedItemHooks atomic.Int64
+
+func (i *upsertedItem) BeforeCreate(*gorm.DB) error {
+	upsertedItemHooks.Add(1)
+	return nil
+}
+
+func Test_Upsert(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("UPSERT_TEST_DB_SQLITE_PATH", "file:upsert_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("upsert_test", "upsert_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&upsertedItem{})
+
+	gm.Expect(conn.Session(func(session *Session) error {
+		item := &upsertedItem{Sku: "A-1", Name: "first", Stock: 1}
+		inserted, err := Upsert(session, item, UpsertOptions{ConflictColumns: []string{"sku"}})
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(inserted).Should(gm.BeTrue())
+		gm.Expect(item.Id).ShouldNot(gm.BeZero())
+
+		batch := []upsertedItem{
+			{Sku: "A-1", Name: "renamed", Stock: 5},
+			{Sku: "B-2", Name: "second", Stock: 2},
+			{Sku: "C-3", Name: "third", Stock: 3},
+		}
+		result, err := UpsertBatch(session, batch, UpsertOptions{ConflictColumns: []string{"sku"}, UpdateColumns: []string{"stock"}})
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(result.Inserted).Should(gm.Equal([]int{1, 2}))
+		gm.Expect(result.Updated).Should(gm.Equal([]int{0}))
+		gm.Expect(result.Skipped).Should(gm.BeEmpty())
+
+		var stored upsertedItem
+		gm.Expect(session.Where("sku = ?", "A-1").Take(&stored).Error).Should(gm.BeNil())
+		gm.Expect(stored.Name).Should(gm.Equal("first"))
+		gm.Expect(stored.Stock).Should(gm.Equal(5))
+
+		result, err = UpsertBatch(session, []upsertedItem{{Sku: "A-1", Name: "again", Stock: 7}}, UpsertOptions{ConflictColumns: []string{"sku"}, ExceptColumns: []string{"stock"}})
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(result.Updated).Should(gm.Equal([]int{0}))
+		gm.Expect(session.Where("sku = ?", "A-1").Take(&stored).Error).Should(gm.BeNil())
+		gm.Expect(stored.Name).Should(gm.Equal("again"))
+		gm.Expect(stored.Stock).Should(gm.Equal(5))
+
+		result, err = UpsertBatch(session, []upsertedItem{{Sku: "B-2", Name: "ignored"}, {Sku: "D-4", Name: "fourth"}}, UpsertOptions{ConflictColumns: []string{"sku"}, DoNothing: true})
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(result.Inserted).Should(gm.Equal([]int{1}))
+		gm.Expect(result.Skipped).Should(gm.Equal([]int{0}))
+
+		var count int64
+		gm.Expect(session.Model(&upsertedItem{}).Count(&count).Error).Should(gm.BeNil())
+		gm.Expect(count).Should(gm.Equal(int64(4)))
+
+		_, err = UpsertBatch(session, []upsertedItem{{Sku: "E-5"}}, UpsertOptions{ConflictColumns: []string{"missing"}})
+		gm.Expect(errors.Is(err, ErrUpsertColumns)).Should(gm.BeTrue())
+
+		_, err = UpsertBatch(session, []upsertedItem{{Sku: "E-5", Stock: 1}, {Sku: "E-5", Stock: 2}}, UpsertOptions{ConflictColumns: []string{"sku"}})
+		gm.Expect(errors.Is(err, ErrUpsertDuplicateKey)).Should(gm.BeTrue())
+
+		generated := []upsertedItem{{Sku: "F-6", Name: "sixth"}, {Sku: "G-7", Name: "seventh"}}
+		result, err = UpsertBatch(session, generated, UpsertOptions{})
+		gm.Expect(err).Should(gm.BeNil())
+		gm.Expect(result.Inserted).Should(gm.Equal([]int{0, 1}))
+		gm.Expect(generated[0].Id).ShouldNot(gm.BeZero())
+		gm.Expect(generated[1].Id).ShouldNot(gm.BeZero())
+		return nil
+	})).Should(gm.BeNil())
+	gm.Expect(upsertedItemHooks.Load()).Should(gm.BeZero())
+}
+
+func Test_PostgresUpsertStatement(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	gormDb, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost user=app dbname=test"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	gm.Expect(err).Should(gm.BeNil())
+	session := newSession(context.Background(), gormDb)
+
+	stmt := &gorm.Statement{DB: gormDb}
+	gm.Expect(stmt.Parse(&upsertedItem{})).Should(gm.BeNil())
+	conflictFields, onConflict, err := upsertClause(stmt.Schema, UpsertOptions{ConflictColumns: []string{"sku"}, UpdateColumns: []string{"stock"}})
+	gm.Expect(err).Should(gm.BeNil())
+	sql := upsertReturningStatement(session, stmt.Schema, []upsertedItem{{Sku: "A-1"}}, conflictFields, onConflict).SQL.String()
+	gm.Expect(sql).Should(gm.ContainSubstring(`ON CONFLICT ("sku") DO UPDATE SET "stock"="excluded"."stock"`))
+	gm.Expect(sql).Should(gm.ContainSubstring(`RETURNING (xmax = 0) AS ctx_base_inserted,"id","sku"`))
+}
+
+func Test_UpsertRowOwner(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	generated := []upsertRowKey{{generated: true}, {generated: true}}
+	gm.Expect(upsertRowOwner(generated, 0, "17")).Should(gm.Equal(0))
+	gm.Expect(upsertRowOwner(generated, 1, "18")).Should(gm.Equal(1))
+
+	mixed := []upsertRowKey{{key: "A-1"}, {generated: true}, {key: "C-3"}}
+	gm.Expect(upsertRowOwner(mixed, 0, "42")).Should(gm.Equal(1))
+	gm.Expect(upsertRowOwner(mixed, 2, "C-3")).Should(gm.Equal(2))
+	gm.Expect(upsertRowOwner(mixed[2:], 0, "B-2")).Should(gm.Equal(1))
+}
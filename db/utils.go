@@ -3,9 +3,13 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/sedmess/go-ctx-base/utils/channels"
 	"github.com/sedmess/go-ctx/logger"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
 )
 
 const DefaultFetchSize = 1000
@@ -57,14 +61,151 @@ func (p *paginator) Offset() int {
 	return p.offset
 }
 
+type KeysetColumn struct {
+	Name string
+	Desc bool
+}
+
+func Asc(name string) KeysetColumn {
+	return KeysetColumn{Name: name}
+}
+
+func Desc(name string) KeysetColumn {
+	return KeysetColumn{Name: name, Desc: true}
+}
+
+type keysetPaginator struct {
+	fetchSize int
+	columns   []KeysetColumn
+	fetched   int
+	last      []any
+	hasNext   bool
+	err       error
+	scopeFn   func(db *gorm.DB) *gorm.DB
+}
+
+func NewKeysetPaginator(fetchSize int, columns ...KeysetColumn) Paginator {
+	p := &keysetPaginator{fetchSize: fetchSize, columns: columns, hasNext: true}
+	if len(columns) == 0 {
+		p.err = errors.New("keyset paginator requires at least one column")
+	}
+	p.scopeFn = func(db *gorm.DB) *gorm.DB {
+		if p.err != nil {
+			_ = db.AddError(p.err)
+			return db
+		}
+		if p.last != nil {
+			db = db.Where(p.seekExpr(db.Dialector.Name() != DriverSQLServer))
+		}
+		for _, column := range p.columns {
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: column.Name}, Desc: column.Desc})
+		}
+		return db.Limit(p.fetchSize)
+	}
+	return p
+}
+
+func (p *keysetPaginator) seekExpr(rowValues bool) clause.Expression {
+	sameDirection := true
+	for _, column := range p.columns {
+		if column.Desc != p.columns[0].Desc {
+			sameDirection = false
+			break
+		}
+	}
+
+	if sameDirection && rowValues {
+		operator := " > "
+		if p.columns[0].Desc {
+			operator = " < "
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(p.columns)), ", ")
+		vars := make([]any, 0, 2*len(p.columns))
+		for _, column := range p.columns {
+			vars = append(vars, clause.Column{Name: column.Name})
+		}
+		vars = append(vars, p.last...)
+		return clause.Expr{SQL: "(" + placeholders + ")" + operator + "(" + placeholders + ")", Vars: vars}
+	}
+
+	alternatives := make([]clause.Expression, 0, len(p.columns))
+	for i, column := range p.columns {
+		conditions := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, clause.Eq{Column: clause.Column{Name: p.columns[j].Name}, Value: p.last[j]})
+		}
+		if column.Desc {
+			conditions = append(conditions, clause.Lt{Column: clause.Column{Name: column.Name}, Value: p.last[i]})
+		} else {
+			conditions = append(conditions, clause.Gt{Column: clause.Column{Name: column.Name}, Value: p.last[i]})
+		}
+		alternatives = append(alternatives, clause.And(conditions...))
+	}
+	return clause.Or(alternatives...)
+}
+
+func (p *keysetPaginator) Scope() func(db *gorm.DB) *gorm.DB {
+	return p.scopeFn
+}
+
+func (p *keysetPaginator) OffsetResult(result *gorm.DB) {
+	if result.RowsAffected < int64(p.fetchSize) {
+		p.hasNext = false
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	p.fetched += int(result.RowsAffected)
+
+	rows := reflect.Indirect(result.Statement.ReflectValue)
+	if rows.Kind() != reflect.Slice || rows.Len() == 0 || result.Statement.Schema == nil {
+		p.err = errors.New("keyset paginator requires a slice of models as a destination")
+		return
+	}
+	lastRow := reflect.Indirect(rows.Index(rows.Len() - 1))
+	last := make([]any, len(p.columns))
+	for i, column := range p.columns {
+		field := result.Statement.Schema.LookUpField(column.Name)
+		if field == nil {
+			p.err = fmt.Errorf("keyset column %s not found in %s", column.Name, result.Statement.Schema.Name)
+			return
+		}
+		last[i], _ = field.ValueOf(result.Statement.Context, lastRow)
+	}
+	p.last = last
+}
+
+func (p *keysetPaginator) HasNext() bool {
+	return p.hasNext
+}
+
+func (p *keysetPaginator) Limit() int {
+	return p.fetchSize
+}
+
+func (p *keysetPaginator) Offset() int {
+	return p.fetched
+}
+
 func SessionStream[T any](connection Connection, fetchSize int, selectFn func(session *gorm.DB) *gorm.DB) channels.StreamingChan[T] {
 	return SessionContextStream[T](context.Background(), connection, fetchSize, selectFn)
 }
 
 func SessionContextStream[T any](ctx context.Context, connection Connection, fetchSize int, selectFn func(session *gorm.DB) *gorm.DB) channels.StreamingChan[T] {
-	paginator := NewPaginator(fetchSize)
-	return channels.CreateChannelBuffered[T](fetchSize, func(sink func(data []T, context context.Context) bool) error {
-		return connection.SessionContext(ctx, func(session *Session) error {
+	return sessionContextPaginatedStream[T](ctx, connection, NewPaginator(fetchSize), selectFn)
+}
+
+func SessionKeysetStream[T any](connection Connection, fetchSize int, keys []KeysetColumn, selectFn func(session *gorm.DB) *gorm.DB) channels.StreamingChan[T] {
+	return SessionContextKeysetStream[T](context.Background(), connection, fetchSize, keys, selectFn)
+}
+
+func SessionContextKeysetStream[T any](ctx context.Context, connection Connection, fetchSize int, keys []KeysetColumn, selectFn func(session *gorm.DB) *gorm.DB) channels.StreamingChan[T] {
+	return sessionContextPaginatedStream[T](ctx, connection, NewKeysetPaginator(fetchSize, keys...), selectFn)
+}
+
+func sessionContextPaginatedStream[T any](ctx context.Context, connection Connection, paginator Paginator, selectFn func(session *gorm.DB) *gorm.DB) channels.StreamingChan[T] {
+	return channels.CreateChannelBuffered[T](paginator.Limit(), func(sink func(data []T, context context.Context) bool) error {
+		return connection.SessionContext(streamContext(ctx), func(session *Session) error {
 			for paginator.HasNext() {
 				var list []T
 				result := selectFn(session.Scopes(paginator.Scope())).Find(&list)
@@ -102,29 +243,29 @@ func SessionContextReturning[T any](context context.Context, connection Connecti
 	return value, err
 }
 
-func TxReturning[T any](session *Session, txFn func(session *Session) (T, error)) (T, error) {
+func TxReturning[T any](session *Session, txFn func(session *Session) (T, error), opts ...*TxOptions) (T, error) {
 	var value T
 	err := session.Tx(func(session *Session) error {
 		var err error
 		value, err = txFn(session)
 		return err
-	})
+	}, opts...)
 	return value, err
 }
 
-func SessionTxReturning[T any](connection Connection, txFn func(session *Session) (T, error)) (T, error) {
+func SessionTxReturning[T any](connection Connection, txFn func(session *Session) (T, error), opts ...*TxOptions) (T, error) {
 	return SessionReturning(connection, func(session *Session) (T, error) {
 		return TxReturning(session, func(session *Session) (T, error) {
 			return txFn(session)
-		})
+		}, opts...)
 	})
 }
 
-func SessionContextTxReturning[T any](context context.Context, connection Connection, txFn func(session *Session) (T, error)) (T, error) {
+func SessionContextTxReturning[T any](context context.Context, connection Connection, txFn func(session *Session) (T, error), opts ...*TxOptions) (T, error) {
 	return SessionContextReturning(context, connection, func(session *Session) (T, error) {
 		return TxReturning(session, func(session *Session) (T, error) {
 			return txFn(session)
-		})
+		}, opts...)
 	})
 }
 
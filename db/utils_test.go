@@ -0,0 +1,75 @@
+package db
+
+import (
+	gm "github.com/onsi/gomega"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+)
+
+type keysetItem struct {
+	Id    int64 `gorm:"primaryKey"`
+	Group int
+	Name  string
+}
+
+func Test_SessionKeysetStream(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("KEYSET_TEST_DB_SQLITE_PATH", "file:keyset_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("keyset_test", "keyset_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&keysetItem{})
+
+	items := make([]keysetItem, 0)
+	for i := 1; i <= 25; i++ {
+		items = append(items, keysetItem{Id: int64(i), Group: i % 3, Name: "item"})
+	}
+	gm.Expect(conn.Session(func(session *Session) error {
+		return session.Create(&items).Error
+	})).Should(gm.BeNil())
+
+	streamed, err := SessionKeysetStream[keysetItem](conn, 4, []KeysetColumn{Asc("group"), Desc("id")}, func(session *gorm.DB) *gorm.DB {
+		return session.Where("name = ?", "item")
+	}).CollectToSlice()
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(streamed).Should(gm.HaveLen(25))
+	for i := 1; i < len(streamed); i++ {
+		prev, cur := streamed[i-1], streamed[i]
+		gm.Expect(prev.Group < cur.Group || prev.Group == cur.Group && prev.Id > cur.Id).Should(gm.BeTrue())
+	}
+
+	streamed, err = SessionKeysetStream[keysetItem](conn, 5, []KeysetColumn{Asc("group"), Asc("id")}, func(session *gorm.DB) *gorm.DB {
+		return session
+	}).CollectToSlice()
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(streamed).Should(gm.HaveLen(25))
+	gm.Expect(streamed[0].Id).Should(gm.Equal(int64(3)))
+	gm.Expect(streamed[24].Id).Should(gm.Equal(int64(23)))
+}
+
+func Test_SessionCursorStream(t *testing.T) {
+	gm.RegisterTestingT(t)
+
+	_ = os.Setenv("CURSOR_TEST_DB_SQLITE_PATH", "file:cursor_test:?mode=memory&cache=shared")
+
+	conn := NewConnection("cursor_test", "cursor_test", false, false)
+	conn.Init()
+	conn.AutoMigrate(&keysetItem{})
+
+	items := make([]keysetItem, 0)
+	for i := 1; i <= 10; i++ {
+		items = append(items, keysetItem{Id: int64(i), Group: i % 2, Name: "item"})
+	}
+	gm.Expect(conn.Session(func(session *Session) error {
+		return session.Create(&items).Error
+	})).Should(gm.BeNil())
+
+	streamed, err := SessionCursorStream[keysetItem](conn, 3, func(session *gorm.DB) *gorm.DB {
+		return session.Where("\"group\" = ?", 1).Order("id")
+	}).CollectToSlice()
+	gm.Expect(err).Should(gm.BeNil())
+	gm.Expect(streamed).Should(gm.HaveLen(5))
+	gm.Expect(streamed[4].Id).Should(gm.Equal(int64(9)))
+}
@@ -68,7 +68,7 @@ func (r *typedRqHandler[T]) Handler(handler func(request *RequestData, body T) R
 		resp := handler((*RequestData)(r), rq)
 		if resp.err != nil {
 			logger.Error("on handling request:", resp.err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(errorStatus(resp.err))
 			return
 		}
 
@@ -120,7 +120,7 @@ func (r *rqHandler) Handler(handler func(request *RequestData) Response) {
 		resp := handler(request)
 		if resp.err != nil {
 			logger.Error("on handling request:", resp.err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(errorStatus(resp.err))
 			return nil
 		}
 
@@ -146,7 +146,7 @@ func (r *rqHandler) HandlerRaw(handler func(request *RequestData, responseWriter
 		err := handler((*RequestData)(r), w)
 		if err != nil {
 			logger.Error("on handling request:", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(errorStatus(err))
 			return
 		}
 	}
@@ -1,6 +1,7 @@
 package httpserver
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -45,6 +46,12 @@ func (h *Response) NotFound() *Response {
 	return h
 }
 
+func (h *Response) Conflict() *Response {
+	h.httpStatus = http.StatusConflict
+	h.err = nil
+	return h
+}
+
 func (h *Response) BadRequestReason(reason ...string) *Response {
 	h.httpStatus = http.StatusBadRequest
 	h.content = fmt.Sprint(reason)
@@ -63,3 +70,15 @@ func (h *Response) Error(err error) *Response {
 	h.err = err
 	return h
 }
+
+type httpStatusError interface {
+	HTTPStatus() int
+}
+
+func errorStatus(err error) int {
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.HTTPStatus()
+	}
+	return http.StatusInternalServerError
+}
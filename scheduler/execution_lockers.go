@@ -16,10 +16,11 @@ const schedulerLockProviderKey = "SCHEDULER_LOCK_PROVIDER"
 
 const (
 	providerPostgres = "POSTGRES"
+	providerDB       = "DB"
 	providerLocal    = "LOCAL"
 )
 
-var alreadyLockedErr = errors.New("resource has already locked")
+var ErrAlreadyLocked = errors.New("resource has already locked")
 
 type Locker struct {
 	l logger.Logger `logger:""`
@@ -35,9 +36,14 @@ func (instance *Locker) Init() {
 	case providerLocal:
 		instance.mu = sync.Mutex{}
 		instance.lm = make(map[string]any)
-	case providerPostgres:
+	case providerPostgres, providerDB:
 		instance.db = db.NewConnection("scheduler", "SCHEDULER", false, true)
 		instance.db.Init()
+		if instance.db.Info().Driver == db.DriverSQLite {
+			instance.l.Info("advisory locks are not supported by", db.DriverSQLite, "- falling back to", providerLocal, "locker")
+			instance.lm = make(map[string]any)
+			provider = providerLocal
+		}
 	default:
 		instance.l.Fatal("unknown", schedulerLockProviderKey, ":", provider)
 	}
@@ -53,37 +59,32 @@ func (instance *Locker) Health() health.ServiceHealth {
 }
 
 func (instance *Locker) Lock(context context.Context, key string) (gocron.Lock, error) {
-	if instance.db != nil {
+	if instance.lm == nil {
 		acquireErrCh := make(chan error)
 		releaseCh := make(chan bool)
 
 		go func() {
 			err := instance.db.SessionContext(context, func(session *db.Session) error {
-				tx := session.Begin()
-				defer tx.Commit()
-				var acquired bool
-				result := tx.Raw("select pg_try_advisory_xact_lock(('x'||md5(?))::bit(64)::bigint) as success;", key).Find(&acquired)
-				if result.Error != nil {
-					acquireErrCh <- result.Error
-					return result.Error
-				}
-				if acquired {
-					acquireErrCh <- nil
-					<-releaseCh
-					return nil
-				} else {
-					acquireErrCh <- alreadyLockedErr
+				unlock, err := db.TryAdvisoryLock(session, key)
+				if errors.Is(err, db.ErrAdvisoryLockNotAcquired) {
+					acquireErrCh <- ErrAlreadyLocked
 					return nil
+				} else if err != nil {
+					acquireErrCh <- err
+					return err
 				}
+				acquireErrCh <- nil
+				<-releaseCh
+				return unlock()
 			})
 			if err != nil {
-				instance.l.Error("on lock tx:", err)
+				instance.l.Error("on lock session:", err)
 			}
 		}()
 
 		acquireErr := <-acquireErrCh
 		if acquireErr == nil {
-			return &txLock{ch: releaseCh}, nil
+			return &sessionLock{ch: releaseCh}, nil
 		} else {
 			return nil, acquireErr
 		}
@@ -93,7 +94,7 @@ func (instance *Locker) Lock(context context.Context, key string) (gocron.Lock,
 
 		_, found := instance.lm[key]
 		if found {
-			return nil, alreadyLockedErr
+			return nil, ErrAlreadyLocked
 		}
 		instance.lm[key] = true
 		return &localLock{locker: instance, key: key}, nil
@@ -114,11 +115,11 @@ func (l *localLock) Unlock(context.Context) error {
 	return nil
 }
 
-type txLock struct {
+type sessionLock struct {
 	ch chan bool
 }
 
-func (l *txLock) Unlock(context.Context) error {
+func (l *sessionLock) Unlock(context.Context) error {
 	l.ch <- true
 	return nil
 }